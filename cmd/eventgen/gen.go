@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+var errUnsupportedSchema = errors.New("schema can't be turned into a Go type")
+
+// generator writes the code of the registry, the nested objects
+// become types of their own, which are written after the event types
+type generator struct {
+	buf     bytes.Buffer
+	pending []*objectType
+}
+
+type objectType struct {
+	name   string
+	schema *schema.Schema
+}
+
+func generate(registry *schema.Registry, pkg string) ([]byte, error) {
+	g := new(generator)
+
+	registered := registry.Events()
+
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	g.printf("// Names of the events of the registry\nconst (\n")
+
+	for _, name := range names {
+		g.printf("%sEvent = %q\n", camel(name), name)
+	}
+
+	g.printf(")\n\n")
+
+	g.printf("// NewData makes an empty value of the event version, it's nil if the registry has no such version\n")
+	g.printf("func NewData(name string, version int) Data {\nswitch {\n")
+
+	for _, name := range names {
+		for _, version := range registered[name] {
+			g.printf("case name == %sEvent && version == %d:\n", camel(name), version)
+			g.printf("return new(%sV%d)\n", camel(name), version)
+		}
+	}
+
+	g.printf("default:\nreturn nil\n}\n}\n\n")
+
+	for _, name := range names {
+		versions := registered[name]
+
+		for i, version := range versions {
+			schema, err := registry.Schema(name, version)
+			if err != nil {
+				return nil, err
+			}
+
+			err = g.event(name, version, schema)
+			if err != nil {
+				return nil, fmt.Errorf("%s v%d: %w", name, version, err)
+			}
+
+			if i+1 < len(versions) {
+				g.upcaster(name, version, versions[i+1])
+			}
+		}
+	}
+
+	for len(g.pending) > 0 {
+		object := g.pending[0]
+		g.pending = g.pending[1:]
+
+		g.printf("// %s is a part of the event data\n", object.name)
+
+		err := g.object(object.name, object.schema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", object.name, err)
+		}
+
+		err = g.validate(object.name, object.schema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", object.name, err)
+		}
+	}
+
+	body := g.buf.String()
+
+	var file bytes.Buffer
+
+	file.WriteString("// Code generated by eventgen from the schema registry. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&file, "package %s\n\nimport (\n", pkg)
+
+	if strings.Contains(body, "json.") {
+		file.WriteString("\"encoding/json\"\n")
+	}
+
+	if strings.Contains(body, "fmt.") {
+		file.WriteString("\"fmt\"\n")
+	}
+
+	file.WriteString("\"time\"\n\n\"github.com/google/uuid\"\n)\n\n")
+	file.WriteString(body)
+
+	return format.Source(file.Bytes())
+}
+
+// event writes the type of the event version along with its methods
+func (g *generator) event(name string, version int, schema *schema.Schema) error {
+	typeName := fmt.Sprintf("%sV%d", camel(name), version)
+
+	g.printf("// %s is the data of the %s v%d event", typeName, name, version)
+
+	if schema.Description != "" {
+		g.printf(", %s", lowerFirst(schema.Description))
+	}
+
+	g.printf("\n")
+
+	err := g.object(typeName, schema)
+	if err != nil {
+		return err
+	}
+
+	g.printf("func (*%s) EventName() string {\nreturn %sEvent\n}\n\n", typeName, camel(name))
+	g.printf("func (*%s) EventVersion() int {\nreturn %d\n}\n\n", typeName, version)
+
+	g.printf("// Envelope wraps the data into the envelope of the event\n")
+	g.printf(
+		"func (d *%s) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {\n",
+		typeName,
+	)
+	g.printf("return newEnvelope(eventID, producer, eventTime, d)\n}\n\n")
+
+	g.printf("// Validate checks the data against the constraints of the schema the Go types don't cover\n")
+	g.printf("func (d *%s) Validate() error {\nreturn d.validate(\"$\")\n}\n\n", typeName)
+
+	g.printf("// On%s registers the handler of the %s v%d event\n", typeName, name, version)
+	g.printf("func (d *Dispatcher) On%s(handler func(envelope *Envelope, data *%s) error) {\n", typeName, typeName)
+	g.printf("d.handle(%sEvent, %d, func(envelope *Envelope) error {\n", camel(name), version)
+	g.printf("data := new(%s)\n\nerr := decodeData(envelope, data)\nif err != nil {\nreturn err\n}\n\n", typeName)
+	g.printf("return handler(envelope, data)\n})\n}\n\n")
+
+	return g.validate(typeName, schema)
+}
+
+// upcaster writes the registration of the upcaster of the event version into the next one
+func (g *generator) upcaster(name string, version, next int) {
+	typeName := fmt.Sprintf("%sV%d", camel(name), version)
+	nextName := fmt.Sprintf("%sV%d", camel(name), next)
+
+	g.printf("// Upcast%s registers the upcaster of the %s v%d event into v%d\n", typeName, name, version, next)
+	g.printf(
+		"func (d *Dispatcher) Upcast%s(upcaster func(data *%s) (*%s, error)) {\n",
+		typeName, typeName, nextName,
+	)
+	g.printf("d.upcast(%sEvent, %d, func(envelope *Envelope) (*Envelope, error) {\n", camel(name), version)
+	g.printf("data := new(%s)\n\nerr := decodeData(envelope, data)\nif err != nil {\nreturn nil, err\n}\n\n", typeName)
+	g.printf("upcast, err := upcaster(data)\nif err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("return upcastEnvelope(envelope, upcast)\n})\n}\n\n")
+}
+
+// object writes the struct of the object
+func (g *generator) object(typeName string, schema *schema.Schema) error {
+	required := make(map[string]bool, len(schema.Required))
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	g.printf("type %s struct {\n", typeName)
+
+	for _, name := range orderedFields(schema) {
+		property := schema.Properties[name]
+
+		typ, err := g.goType(typeName+camel(name), property)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if property.Description != "" {
+			g.printf("// %s\n", property.Description)
+		}
+
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+
+		g.printf("%s %s `json:%q`\n", camel(name), typ, tag)
+	}
+
+	g.printf("}\n\n")
+
+	return nil
+}
+
+// validate writes the validation of the object
+func (g *generator) validate(typeName string, schema *schema.Schema) error {
+	g.printf("func (d *%s) validate(path string) error {\n", typeName)
+
+	for _, name := range orderedFields(schema) {
+		err := g.checks("d."+camel(name), schema.Properties[name], "path + "+strconv.Quote("."+name), 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	g.printf("return nil\n}\n\n")
+
+	return nil
+}
+
+// goType picks the Go type the schema values are decoded into
+func (g *generator) goType(nestedName string, schema *schema.Schema) (string, error) {
+	typ, nullable, err := splitType(schema)
+	if err != nil {
+		return "", err
+	}
+
+	var goType string
+
+	switch typ {
+	case "":
+		return "json.RawMessage", nil
+	case "string":
+		switch schema.Format {
+		case "uuid":
+			if nullable {
+				return "uuid.NullUUID", nil
+			}
+
+			return "uuid.UUID", nil
+		case "date-time":
+			goType = "time.Time"
+		default:
+			goType = "string"
+		}
+	case "integer":
+		goType = "int64"
+	case "number":
+		goType = "float64"
+	case "boolean":
+		goType = "bool"
+	case "array":
+		if schema.Items == nil {
+			return "[]json.RawMessage", nil
+		}
+
+		item, err := g.goType(nestedName+"Item", schema.Items)
+		if err != nil {
+			return "", err
+		}
+
+		// A nil slice is the null
+		return "[]" + item, nil
+	case "object":
+		if len(schema.Properties) == 0 {
+			goType = "map[string]json.RawMessage"
+			break
+		}
+
+		g.pending = append(g.pending, &objectType{name: nestedName, schema: schema})
+		goType = nestedName
+	}
+
+	if nullable {
+		return "*" + goType, nil
+	}
+
+	return goType, nil
+}
+
+// checks writes the checks of the value the Go type doesn't make by itself
+func (g *generator) checks(expr string, schema *schema.Schema, path string, depth int) error {
+	if !needsChecks(schema) {
+		return nil
+	}
+
+	typ, nullable, err := splitType(schema)
+	if err != nil {
+		return err
+	}
+
+	if len(schema.Enum) > 0 && (typ != "string" || schema.Format != "") {
+		return fmt.Errorf("%w: enum is supported on plain strings only", errUnsupportedSchema)
+	}
+
+	switch typ {
+	case "string":
+		value := expr
+		if nullable {
+			g.printf("if %s != nil {\n", expr)
+			value = "*" + expr
+		}
+
+		if schema.MinLength != nil || schema.MaxLength != nil {
+			g.printf("if err := checkLength(%s, %s, %d, %d); err != nil {\nreturn err\n}\n",
+				value, path, limit(schema.MinLength), limit(schema.MaxLength))
+		}
+
+		if len(schema.Enum) > 0 {
+			allowed := make([]string, 0, len(schema.Enum))
+			for _, v := range schema.Enum {
+				allowed = append(allowed, strconv.Quote(fmt.Sprint(v)))
+			}
+
+			g.printf("if err := checkEnum(%s, %s, %s); err != nil {\nreturn err\n}\n",
+				value, path, strings.Join(allowed, ", "))
+		}
+
+		if nullable {
+			g.printf("}\n")
+		}
+	case "array":
+		if !nullable {
+			g.printf("if err := checkNotNull(%s == nil, %s); err != nil {\nreturn err\n}\n", expr, path)
+		}
+
+		if schema.Items == nil || !needsChecks(schema.Items) {
+			return nil
+		}
+
+		index, item := fmt.Sprintf("i%d", depth), fmt.Sprintf("item%d", depth)
+
+		g.printf("for %s := range %s {\n%s := %s[%s]\n", index, expr, item, expr, index)
+
+		err = g.checks(item, schema.Items, fmt.Sprintf("fmt.Sprintf(\"%%s[%%d]\", %s, %s)", path, index), depth+1)
+		if err != nil {
+			return err
+		}
+
+		g.printf("}\n")
+	case "object":
+		if nullable {
+			g.printf("if %s != nil {\n", expr)
+		}
+
+		g.printf("if err := %s.validate(%s); err != nil {\nreturn err\n}\n", expr, path)
+
+		if nullable {
+			g.printf("}\n")
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// needsChecks tells whether the values of the schema need the checks the Go types don't make
+func needsChecks(schema *schema.Schema) bool {
+	typ, nullable, err := splitType(schema)
+	if err != nil {
+		// The error is reported by the check itself
+		return true
+	}
+
+	switch typ {
+	case "string":
+		return schema.MinLength != nil || schema.MaxLength != nil || len(schema.Enum) > 0
+	case "array":
+		return !nullable || (schema.Items != nil && needsChecks(schema.Items))
+	case "object":
+		return len(schema.Properties) > 0
+	default:
+		return len(schema.Enum) > 0
+	}
+}
+
+// splitType gets the single type of the schema apart from the null
+func splitType(schema *schema.Schema) (typ string, nullable bool, err error) {
+	types := make([]string, 0, len(schema.Type))
+
+	for _, t := range schema.Type {
+		if t == "null" {
+			nullable = true
+			continue
+		}
+
+		types = append(types, t)
+	}
+
+	switch len(types) {
+	case 0:
+		return "", nullable, nil
+	case 1:
+		return types[0], nullable, nil
+	default:
+		return "", false, fmt.Errorf("%w: union of %s", errUnsupportedSchema, strings.Join(types, ", "))
+	}
+}
+
+// orderedFields lists the required properties in the order of the schema, the rest go after them by name
+func orderedFields(schema *schema.Schema) []string {
+	fields := make([]string, 0, len(schema.Properties))
+	listed := make(map[string]bool, len(schema.Properties))
+
+	for _, name := range schema.Required {
+		fields = append(fields, name)
+		listed[name] = true
+	}
+
+	rest := make([]string, 0)
+
+	for name := range schema.Properties {
+		if !listed[name] {
+			rest = append(rest, name)
+		}
+	}
+
+	sort.Strings(rest)
+
+	return append(fields, rest...)
+}
+
+func limit(value *int) int {
+	if value == nil {
+		return -1
+	}
+
+	return *value
+}
+
+// camel turns the snake case name into a Go one, e.g. prev_assignee_id into PrevAssigneeID
+func camel(name string) string {
+	var b strings.Builder
+
+	for _, part := range strings.Split(name, "_") {
+		switch part {
+		case "id", "ids", "url", "uri", "api", "json", "uuid", "http":
+			b.WriteString(strings.ToUpper(strings.TrimSuffix(part, "s")))
+
+			if strings.HasSuffix(part, "s") {
+				b.WriteString("s")
+			}
+		default:
+			b.WriteString(upperFirst(part))
+		}
+	}
+
+	return b.String()
+}
+
+func upperFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
@@ -0,0 +1,35 @@
+// Command eventgen generates the Go types of the events of the schema registry: a struct per
+// event version along with its envelope constructor, validator and dispatcher registration.
+// It's run by go generate in pkg/events whenever a schema is added or changed
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+func main() {
+	registryDir := flag.String("registry", "pkg/events/schemas", "directory of the schema registry")
+	out := flag.String("out", "pkg/events/events_gen.go", "file of the generated code")
+	pkg := flag.String("package", "events", "package of the generated code")
+	flag.Parse()
+
+	registry, err := schema.LoadRegistry(os.DirFS(*registryDir))
+	if err != nil {
+		log.Fatalf("schema.LoadRegistry error: %s", err.Error())
+	}
+
+	code, err := generate(registry, *pkg)
+	if err != nil {
+		log.Fatalf("generate error: %s", err.Error())
+	}
+
+	//nolint:gosec // The generated code is a regular source file
+	err = os.WriteFile(*out, code, 0o644)
+	if err != nil {
+		log.Fatalf("os.WriteFile error: %s", err.Error())
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+// breakingChanges lists the changes of the schema which break the producers or the consumers
+// of the previous one: removed fields, changed types, new required fields and new fields
+// of an object which allowed no additional ones, the previous consumers reject them
+func breakingChanges(prev, next *schema.Schema, path string) []string {
+	changes := make([]string, 0)
+
+	prevType, nextType := typeOf(prev), typeOf(next)
+	if prevType != nextType {
+		changes = append(changes, fmt.Sprintf("%s: type changed from %s to %s", path, prevType, nextType))
+		return changes
+	}
+
+	required := make(map[string]bool, len(prev.Required))
+	for _, name := range prev.Required {
+		required[name] = true
+	}
+
+	for _, name := range next.Required {
+		if !required[name] {
+			changes = append(changes, fmt.Sprintf("%s.%s: new required field", path, name))
+		}
+	}
+
+	for _, name := range sortedProperties(prev) {
+		property, ok := next.Properties[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("%s.%s: field removed", path, name))
+			continue
+		}
+
+		changes = append(changes, breakingChanges(prev.Properties[name], property, path+"."+name)...)
+	}
+
+	if closed(prev) {
+		for _, name := range sortedProperties(next) {
+			if _, ok := prev.Properties[name]; !ok {
+				changes = append(changes, fmt.Sprintf("%s.%s: field added to a closed object", path, name))
+			}
+		}
+	} else if closed(next) {
+		changes = append(changes, fmt.Sprintf("%s: additional fields disallowed", path))
+	}
+
+	if prev.Items != nil && next.Items != nil {
+		changes = append(changes, breakingChanges(prev.Items, next.Items, path+"[]")...)
+	}
+
+	return changes
+}
+
+// typeOf renders the type along with the format, a changed format is a changed type as well
+func typeOf(schema *schema.Schema) string {
+	types := append([]string(nil), schema.Type...)
+	sort.Strings(types)
+
+	typ := strings.Join(types, "|")
+	if typ == "" {
+		typ = "any"
+	}
+
+	if schema.Format != "" {
+		typ += " (" + schema.Format + ")"
+	}
+
+	return typ
+}
+
+// closed tells whether the object allows no properties out of its list
+func closed(schema *schema.Schema) bool {
+	return schema.AdditionalProperties != nil && !*schema.AdditionalProperties
+}
+
+func sortedProperties(schema *schema.Schema) []string {
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
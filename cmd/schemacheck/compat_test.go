@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+func TestBreakingChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		prev    string
+		next    string
+		changes []string
+	}{
+		{
+			name:    "unchanged",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+			changes: []string{},
+		},
+		{
+			name:    "optional field added to an open object",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}, "title": {"type": "string"}}}`,
+			changes: []string{},
+		},
+		{
+			name: "optional field added to a closed object",
+			prev: `{"type": "object", "properties": {"id": {"type": "string"}}, "additionalProperties": false}`,
+			next: `{
+				"type": "object",
+				"properties": {"id": {"type": "string"}, "title": {"type": "string"}},
+				"additionalProperties": false
+			}`,
+			changes: []string{"$.title: field added to a closed object"},
+		},
+		{
+			name:    "additional fields disallowed",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}}, "additionalProperties": false}`,
+			changes: []string{"$: additional fields disallowed"},
+		},
+		{
+			name:    "field removed",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}, "title": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			changes: []string{"$.title: field removed"},
+		},
+		{
+			name:    "new required field",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+			changes: []string{"$.id: new required field"},
+		},
+		{
+			name:    "type changed",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "integer"}}}`,
+			changes: []string{"$.id: type changed from string to integer"},
+		},
+		{
+			name:    "format changed",
+			prev:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:    `{"type": "object", "properties": {"id": {"type": "string", "format": "uuid"}}}`,
+			changes: []string{"$.id: type changed from string to string (uuid)"},
+		},
+		{
+			name:    "item type changed",
+			prev:    `{"type": "array", "items": {"type": "string"}}`,
+			next:    `{"type": "array", "items": {"type": "integer"}}`,
+			changes: []string{"$[]: type changed from string to integer"},
+		},
+		{
+			name: "nested field added to a closed object",
+			prev: `{
+				"type": "object",
+				"properties": {"task": {"type": "object", "properties": {}, "additionalProperties": false}}
+			}`,
+			next: `{
+				"type": "object",
+				"properties": {
+					"task": {"type": "object", "properties": {"id": {"type": "string"}}, "additionalProperties": false}
+				}
+			}`,
+			changes: []string{"$.task.id: field added to a closed object"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, err := schema.Parse([]byte(tt.prev))
+			if err != nil {
+				t.Fatalf("Parse: %s", err.Error())
+			}
+
+			next, err := schema.Parse([]byte(tt.next))
+			if err != nil {
+				t.Fatalf("Parse: %s", err.Error())
+			}
+
+			changes := breakingChanges(prev, next, "$")
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("breakingChanges: got %q, want %q", changes, tt.changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Command schemacheck guards the event contracts. It compares the schemas of the registry
+// against the previous registry, e.g. the one of the main branch, flagging the breaking changes
+// of the versions which are already published, and checks that the generated event types
+// still read and serialize valid instances of the schemas. It exits non-zero on any violation.
+//
+//	git worktree add /tmp/main main
+//	go run ./cmd/schemacheck -base /tmp/main/pkg/events/schemas
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+func main() {
+	registryDir := flag.String("registry", "pkg/events/schemas", "directory of the proposed schema registry")
+	baseDir := flag.String("base", "", "directory of the previous schema registry, the check is skipped if empty")
+	flag.Parse()
+
+	registry, err := schema.LoadRegistry(os.DirFS(*registryDir))
+	if err != nil {
+		log.Fatalf("schema.LoadRegistry error: %s", err.Error())
+	}
+
+	violations := make([]string, 0)
+
+	if *baseDir != "" {
+		base, err := schema.LoadRegistry(os.DirFS(*baseDir))
+		if err != nil {
+			log.Fatalf("schema.LoadRegistry error: %s", err.Error())
+		}
+
+		violations = append(violations, checkBase(base, registry)...)
+	}
+
+	violations = append(violations, checkTypes(registry)...)
+
+	reportVersions(registry)
+
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+
+	if len(violations) > 0 {
+		fmt.Printf("%d violations\n", len(violations))
+		os.Exit(1)
+	}
+
+	fmt.Println("Event contracts are compatible")
+}
+
+// checkBase makes sure the published versions are neither removed nor broken,
+// a breaking change has to go to a new version instead
+func checkBase(base, registry *schema.Registry) []string {
+	violations := make([]string, 0)
+
+	for _, eventName := range sortedEvents(base) {
+		for _, version := range base.Events()[eventName] {
+			prefix := fmt.Sprintf("%s v%d", eventName, version)
+
+			prev, err := base.Schema(eventName, version)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %s", prefix, err.Error()))
+				continue
+			}
+
+			next, err := registry.Schema(eventName, version)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: version removed", prefix))
+				continue
+			}
+
+			for _, change := range breakingChanges(prev, next, "$") {
+				violations = append(violations, fmt.Sprintf("%s: %s", prefix, change))
+			}
+		}
+	}
+
+	return violations
+}
+
+// reportVersions lists the breaking changes between the versions of every event. They are
+// expected, but the consumers of the new version need an upcaster to read the previous one
+func reportVersions(registry *schema.Registry) {
+	for _, eventName := range sortedEvents(registry) {
+		versions := registry.Events()[eventName]
+
+		for i := 1; i < len(versions); i++ {
+			prev, _ := registry.Schema(eventName, versions[i-1])
+			next, _ := registry.Schema(eventName, versions[i])
+
+			for _, change := range breakingChanges(prev, next, "$") {
+				fmt.Printf("note: %s v%d -> v%d: %s\n", eventName, versions[i-1], versions[i], change)
+			}
+		}
+	}
+}
+
+func sortedEvents(registry *schema.Registry) []string {
+	names := make([]string, 0)
+	for name := range registry.Events() {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
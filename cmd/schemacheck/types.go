@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vashc/async_arch_course/pkg/events"
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+const (
+	sampleUUID     = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	sampleDateTime = "2006-01-02T15:04:05Z"
+)
+
+// checkTypes makes sure the generated event types, the ones the command is built with, read
+// a sample of every schema of the registry without losing a field and serialize it back to
+// a valid instance. A type out of step with its schema has to be regenerated
+func checkTypes(registry *schema.Registry) []string {
+	violations := make([]string, 0)
+
+	for _, eventName := range sortedEvents(registry) {
+		for _, version := range registry.Events()[eventName] {
+			prefix := fmt.Sprintf("%s v%d", eventName, version)
+
+			s, err := registry.Schema(eventName, version)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %s", prefix, err.Error()))
+				continue
+			}
+
+			err = checkType(s, events.NewData(eventName, version))
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %s", prefix, err.Error()))
+			}
+		}
+	}
+
+	return violations
+}
+
+func checkType(s *schema.Schema, data events.Data) error {
+	if data == nil {
+		return errors.New("no generated type, the code has to be regenerated")
+	}
+
+	sample, err := json.Marshal(sampleOf(s))
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(sample))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(data)
+	if err != nil {
+		return fmt.Errorf("generated type doesn't read the sample: %s", err.Error())
+	}
+
+	err = data.Validate()
+	if err != nil {
+		return fmt.Errorf("generated type rejects the sample: %s", err.Error())
+	}
+
+	serialized, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	err = s.Validate(serialized)
+	if err != nil {
+		return fmt.Errorf("generated type serializes the sample to %s: %s", serialized, err.Error())
+	}
+
+	return nil
+}
+
+// sampleOf makes a valid instance of the schema with every property set
+func sampleOf(s *schema.Schema) interface{} {
+	if len(s.Enum) > 0 {
+		return s.Enum[0]
+	}
+
+	switch sampleType(s) {
+	case "object":
+		object := make(map[string]interface{}, len(s.Properties))
+		for name, property := range s.Properties {
+			object[name] = sampleOf(property)
+		}
+
+		return object
+	case "array":
+		if s.Items == nil {
+			return []interface{}{}
+		}
+
+		return []interface{}{sampleOf(s.Items)}
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	case "null":
+		return nil
+	default:
+		return sampleString(s)
+	}
+}
+
+// sampleType picks the first type which isn't null, a nullable property gets a value
+func sampleType(s *schema.Schema) string {
+	for _, typ := range s.Type {
+		if typ != "null" {
+			return typ
+		}
+	}
+
+	if len(s.Type) > 0 {
+		return "null"
+	}
+
+	return "string"
+}
+
+func sampleString(s *schema.Schema) string {
+	switch s.Format {
+	case "uuid":
+		return sampleUUID
+	case "date-time":
+		return sampleDateTime
+	}
+
+	length := 1
+	if s.MinLength != nil && *s.MinLength > length {
+		length = *s.MinLength
+	}
+
+	if s.MaxLength != nil && *s.MaxLength < length {
+		length = *s.MaxLength
+	}
+
+	return strings.Repeat("a", length)
+}
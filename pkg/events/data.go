@@ -0,0 +1,200 @@
+package events
+
+//go:generate go run ../../cmd/eventgen -registry schemas -out events_gen.go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+var (
+	ErrNoHandler          = errors.New("event has no handler")
+	ErrUnsupportedVersion = errors.New("event version is neither handled nor upcast to a handled one")
+)
+
+// Data is the data of an event version, the types of the registry events are generated
+type Data interface {
+	EventName() string
+	EventVersion() int
+	Validate() error
+}
+
+// Dispatcher hands the events to the handlers registered for their versions, the handlers are
+// registered through the generated On<Event>V<Version> methods. A version without a handler
+// may be upcast into the next one through the generated Upcast<Event>V<Version> methods, so a
+// consumer of the latest version keeps reading the events of the lagging producers
+type Dispatcher struct {
+	handlers  map[eventKey]func(envelope *Envelope) error
+	upcasters map[eventKey]func(envelope *Envelope) (*Envelope, error)
+}
+
+type eventKey struct {
+	name    string
+	version int
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers:  make(map[eventKey]func(envelope *Envelope) error),
+		upcasters: make(map[eventKey]func(envelope *Envelope) (*Envelope, error)),
+	}
+}
+
+// Dispatch hands the event to the handler of its version, upcasting it until a handled version
+// is reached. It returns ErrNoHandler if the event isn't handled at all and ErrUnsupportedVersion
+// if the other versions of the event are, e.g. the event comes from a newer producer
+func (d *Dispatcher) Dispatch(envelope *Envelope) error {
+	name, version := envelope.EventName, envelope.EventVersion
+
+	for {
+		key := eventKey{name: envelope.EventName, version: envelope.EventVersion}
+
+		handler, ok := d.handlers[key]
+		if ok {
+			return handler(envelope)
+		}
+
+		upcaster, ok := d.upcasters[key]
+		if !ok {
+			break
+		}
+
+		next, err := upcaster(envelope)
+		if err != nil {
+			return fmt.Errorf("upcasting %s v%d: %w", envelope.EventName, envelope.EventVersion, err)
+		}
+
+		envelope = next
+	}
+
+	versions := d.handledVersions(name)
+	if len(versions) == 0 {
+		return fmt.Errorf("%w: %s", ErrNoHandler, name)
+	}
+
+	return fmt.Errorf("%w: %s v%d, the handled versions are %v", ErrUnsupportedVersion, name, version, versions)
+}
+
+// Handles tells whether any version of the event has a handler
+func (d *Dispatcher) Handles(name string) bool {
+	return len(d.handledVersions(name)) > 0
+}
+
+func (d *Dispatcher) handle(name string, version int, handler func(envelope *Envelope) error) {
+	d.handlers[eventKey{name: name, version: version}] = handler
+}
+
+func (d *Dispatcher) upcast(name string, version int, upcaster func(envelope *Envelope) (*Envelope, error)) {
+	d.upcasters[eventKey{name: name, version: version}] = upcaster
+}
+
+func (d *Dispatcher) handledVersions(name string) []int {
+	versions := make([]int, 0)
+
+	for key := range d.handlers {
+		if key.name == name {
+			versions = append(versions, key.version)
+		}
+	}
+
+	sort.Ints(versions)
+
+	return versions
+}
+
+// decodeData reads the event data, checking it the same way as the schema does
+func decodeData(envelope *Envelope, data Data) error {
+	err := json.Unmarshal(envelope.Data, data)
+	if err != nil {
+		return fmt.Errorf("%s v%d: %w: %s", envelope.EventName, envelope.EventVersion, schema.ErrSchemaViolation, err.Error())
+	}
+
+	err = data.Validate()
+	if err != nil {
+		return fmt.Errorf("%s v%d: %w", envelope.EventName, envelope.EventVersion, err)
+	}
+
+	return nil
+}
+
+// upcastEnvelope replaces the data of the envelope with the one of the next version,
+// the metadata of the event stays the same
+func upcastEnvelope(envelope *Envelope, data Data) (*Envelope, error) {
+	err := data.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	upcast := *envelope
+	upcast.EventVersion = data.EventVersion()
+	upcast.Data = body
+
+	return &upcast, nil
+}
+
+func newEnvelope(eventID uuid.UUID, producer string, eventTime time.Time, data Data) (*Envelope, error) {
+	err := data.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		EventID:      eventID,
+		EventName:    data.EventName(),
+		EventVersion: data.EventVersion(),
+		EventTime:    eventTime,
+		Producer:     producer,
+		Data:         body,
+	}, nil
+}
+
+// checkLength is the minLength and maxLength check of the generated validators, a negative limit is no limit
+func checkLength(value, path string, minLength, maxLength int) error {
+	length := utf8.RuneCountInString(value)
+
+	switch {
+	case minLength >= 0 && length < minLength:
+		return fmt.Errorf("%w: %s is shorter than %d", schema.ErrSchemaViolation, path, minLength)
+	case maxLength >= 0 && length > maxLength:
+		return fmt.Errorf("%w: %s is longer than %d", schema.ErrSchemaViolation, path, maxLength)
+	}
+
+	return nil
+}
+
+// checkEnum is the enum check of the generated validators
+func checkEnum(value, path string, allowed ...string) error {
+	for _, v := range allowed {
+		if value == v {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s is not one of the allowed values", schema.ErrSchemaViolation, path)
+}
+
+// checkNotNull fails the slices which aren't allowed to be null in the schema
+func checkNotNull(isNil bool, path string) error {
+	if isNil {
+		return fmt.Errorf("%w: %s is null", schema.ErrSchemaViolation, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func taskCreatedV1(t *testing.T, title string) *Envelope {
+	t.Helper()
+
+	data := &TaskCreatedV1{
+		TaskID:     uuid.New(),
+		BlockedBy:  []uuid.UUID{},
+		Title:      title,
+		Status:     "created",
+		AssigneeID: uuid.New(),
+		Labels:     []string{},
+		Priority:   "normal",
+	}
+
+	envelope, err := data.Envelope(uuid.New(), "task_tracker", time.Now())
+	if err != nil {
+		t.Fatalf("Envelope: %s", err.Error())
+	}
+
+	return envelope
+}
+
+// taskCreatedV2Dispatcher handles task_created v2 only, upcasting v1 into it
+func taskCreatedV2Dispatcher(handler func(envelope *Envelope, data *TaskCreatedV2) error) *Dispatcher {
+	dispatcher := NewDispatcher()
+	dispatcher.UpcastTaskCreatedV1(TaskCreatedV1ToV2)
+	dispatcher.OnTaskCreatedV2(handler)
+
+	return dispatcher
+}
+
+func unexpectedTaskCreatedV2(t *testing.T) func(envelope *Envelope, data *TaskCreatedV2) error {
+	return func(envelope *Envelope, data *TaskCreatedV2) error {
+		t.Errorf("handler: got %s v%d, want none", envelope.EventName, envelope.EventVersion)
+		return nil
+	}
+}
+
+func TestDispatchUpcast(t *testing.T) {
+	tests := []struct {
+		name   string
+		title  string
+		want   string
+		jiraID string
+	}{
+		{
+			name:   "jira prefix",
+			title:  "[UBERPOP-42] Fix the login",
+			want:   "Fix the login",
+			jiraID: "UBERPOP-42",
+		},
+		{
+			name:  "jira id alone",
+			title: "[UBERPOP-42]",
+			want:  "[UBERPOP-42]",
+		},
+		{
+			name:  "no jira id",
+			title: "Fix the login",
+			want:  "Fix the login",
+		},
+	}
+
+	registry, err := NewRegistry()
+	if err != nil {
+		t.Fatalf("NewRegistry: %s", err.Error())
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upcast *Envelope
+			var data *TaskCreatedV2
+
+			dispatcher := taskCreatedV2Dispatcher(func(envelope *Envelope, handled *TaskCreatedV2) error {
+				upcast, data = envelope, handled
+				return nil
+			})
+
+			err := dispatcher.Dispatch(taskCreatedV1(t, tt.title))
+			if err != nil {
+				t.Fatalf("Dispatch: %s", err.Error())
+			}
+
+			if data == nil {
+				t.Fatalf("Dispatch: not handled")
+			}
+
+			if upcast.EventVersion != 2 {
+				t.Errorf("EventVersion: got %d, want 2", upcast.EventVersion)
+			}
+
+			err = registry.Validate(upcast)
+			if err != nil {
+				t.Errorf("Validate: %s", err.Error())
+			}
+
+			if data.Title != tt.want {
+				t.Errorf("Title: got %q, want %q", data.Title, tt.want)
+			}
+
+			switch {
+			case tt.jiraID == "" && data.JiraID != nil:
+				t.Errorf("JiraID: got %q, want nil", *data.JiraID)
+			case tt.jiraID != "" && (data.JiraID == nil || *data.JiraID != tt.jiraID):
+				t.Errorf("JiraID: got %v, want %q", data.JiraID, tt.jiraID)
+			}
+		})
+	}
+}
+
+func TestDispatchUnsupportedVersion(t *testing.T) {
+	dispatcher := taskCreatedV2Dispatcher(unexpectedTaskCreatedV2(t))
+
+	envelope := taskCreatedV1(t, "Fix the login")
+	envelope.EventVersion = 3
+
+	err := dispatcher.Dispatch(envelope)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Dispatch: got %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
+
+func TestDispatchNoHandler(t *testing.T) {
+	dispatcher := taskCreatedV2Dispatcher(unexpectedTaskCreatedV2(t))
+
+	if dispatcher.Handles(TaskCompletedEvent) {
+		t.Errorf("Handles: got true, want false")
+	}
+
+	envelope := taskCreatedV1(t, "Fix the login")
+	envelope.EventName = TaskCompletedEvent
+
+	err := dispatcher.Dispatch(envelope)
+	if !errors.Is(err, ErrNoHandler) {
+		t.Errorf("Dispatch: got %v, want %v", err, ErrNoHandler)
+	}
+}
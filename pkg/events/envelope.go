@@ -0,0 +1,139 @@
+// Package events is the envelope every service wraps its events into,
+// so the producers and the consumers agree on the metadata
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	ContentType = "application/json"
+
+	// HeaderEventVersion duplicates the envelope version,
+	// so the consumers may route the messages without parsing them
+	HeaderEventVersion = "x-event-version"
+)
+
+var ErrInvalidEnvelope = errors.New("event envelope is malformed")
+
+type Envelope struct {
+	EventID      uuid.UUID `json:"event_id"`
+	EventName    string    `json:"event_name"`
+	EventVersion int       `json:"event_version"`
+	EventTime    time.Time `json:"event_time"`
+	Producer     string    `json:"producer"`
+	// CorrelationID ties the events caused by the same change together, the producers
+	// share it between the events stored in one transaction. An event published without
+	// one is correlated by its own ID
+	CorrelationID string          `json:"correlation_id"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Validate checks that every metadata field is set
+func (e *Envelope) Validate() error {
+	switch {
+	case e.EventID == uuid.Nil:
+		return fmt.Errorf("%w: no event_id", ErrInvalidEnvelope)
+	case e.EventName == "":
+		return fmt.Errorf("%w: no event_name", ErrInvalidEnvelope)
+	case e.EventVersion < 1:
+		return fmt.Errorf("%w: event_version %d", ErrInvalidEnvelope, e.EventVersion)
+	case e.EventTime.IsZero():
+		return fmt.Errorf("%w: no event_time", ErrInvalidEnvelope)
+	case e.Producer == "":
+		return fmt.Errorf("%w: no producer", ErrInvalidEnvelope)
+	case e.CorrelationID == "":
+		return fmt.Errorf("%w: no correlation_id", ErrInvalidEnvelope)
+	case len(e.Data) == 0:
+		return fmt.Errorf("%w: no data", ErrInvalidEnvelope)
+	}
+
+	return nil
+}
+
+// Publishing renders the envelope as a message. The metadata goes into the message
+// properties as well, the broker tools and the older consumers read them from there
+func (e *Envelope) Publishing() (amqp.Publishing, error) {
+	if e.CorrelationID == "" {
+		e.CorrelationID = e.EventID.String()
+	}
+
+	err := e.Validate()
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		Headers: amqp.Table{
+			HeaderEventVersion: int32(e.EventVersion),
+		},
+		ContentType:   ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: e.CorrelationID,
+		MessageId:     e.EventID.String(),
+		Timestamp:     e.EventTime,
+		Type:          e.EventName,
+		AppId:         e.Producer,
+		Body:          body,
+	}, nil
+}
+
+// Parse gets the envelope out of the message. The messages published before the envelope
+// carry the bare data, their envelope is made of the message properties, and the event ID
+// is left empty if the message has none
+func Parse(msg *amqp.Delivery) (*Envelope, error) {
+	if msg.ContentType != ContentType {
+		return parseBare(msg)
+	}
+
+	envelope := new(Envelope)
+
+	err := json.Unmarshal(msg.Body, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEnvelope, err.Error())
+	}
+
+	err = envelope.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope, nil
+}
+
+func parseBare(msg *amqp.Delivery) (*Envelope, error) {
+	envelope := &Envelope{
+		EventName:     msg.Type,
+		EventVersion:  1,
+		EventTime:     msg.Timestamp,
+		Producer:      msg.AppId,
+		CorrelationID: msg.CorrelationId,
+		Data:          msg.Body,
+	}
+
+	if msg.MessageId != "" {
+		eventID, err := uuid.Parse(msg.MessageId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: event_id %s", ErrInvalidEnvelope, msg.MessageId)
+		}
+
+		envelope.EventID = eventID
+	}
+
+	if envelope.EventName == "" {
+		return nil, fmt.Errorf("%w: no event_name", ErrInvalidEnvelope)
+	}
+
+	return envelope, nil
+}
@@ -0,0 +1,752 @@
+// Code generated by eventgen from the schema registry. DO NOT EDIT.
+
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Names of the events of the registry
+const (
+	TaskAssignedEvent          = "task_assigned"
+	TaskCancelledEvent         = "task_cancelled"
+	TaskCommentAddedEvent      = "task_comment_added"
+	TaskCompletedEvent         = "task_completed"
+	TaskCreatedEvent           = "task_created"
+	TaskDependencyAddedEvent   = "task_dependency_added"
+	TaskDependencyRemovedEvent = "task_dependency_removed"
+	TaskLabelsUpdatedEvent     = "task_labels_updated"
+	TaskOverdueEvent           = "task_overdue"
+	TaskReopenedEvent          = "task_reopened"
+	TaskUnassignedEvent        = "task_unassigned"
+	UserCreatedEvent           = "user_created"
+	UserUpdatedEvent           = "user_updated"
+)
+
+// NewData makes an empty value of the event version, it's nil if the registry has no such version
+func NewData(name string, version int) Data {
+	switch {
+	case name == TaskAssignedEvent && version == 1:
+		return new(TaskAssignedV1)
+	case name == TaskCancelledEvent && version == 1:
+		return new(TaskCancelledV1)
+	case name == TaskCommentAddedEvent && version == 1:
+		return new(TaskCommentAddedV1)
+	case name == TaskCompletedEvent && version == 1:
+		return new(TaskCompletedV1)
+	case name == TaskCreatedEvent && version == 1:
+		return new(TaskCreatedV1)
+	case name == TaskCreatedEvent && version == 2:
+		return new(TaskCreatedV2)
+	case name == TaskDependencyAddedEvent && version == 1:
+		return new(TaskDependencyAddedV1)
+	case name == TaskDependencyRemovedEvent && version == 1:
+		return new(TaskDependencyRemovedV1)
+	case name == TaskLabelsUpdatedEvent && version == 1:
+		return new(TaskLabelsUpdatedV1)
+	case name == TaskOverdueEvent && version == 1:
+		return new(TaskOverdueV1)
+	case name == TaskReopenedEvent && version == 1:
+		return new(TaskReopenedV1)
+	case name == TaskUnassignedEvent && version == 1:
+		return new(TaskUnassignedV1)
+	case name == UserCreatedEvent && version == 1:
+		return new(UserCreatedV1)
+	case name == UserUpdatedEvent && version == 1:
+		return new(UserUpdatedV1)
+	default:
+		return nil
+	}
+}
+
+// TaskAssignedV1 is the data of the task_assigned v1 event, a task has been handed over to a worker
+type TaskAssignedV1 struct {
+	TaskID     uuid.UUID `json:"task_id"`
+	AssigneeID uuid.UUID `json:"assignee_id"`
+}
+
+func (*TaskAssignedV1) EventName() string {
+	return TaskAssignedEvent
+}
+
+func (*TaskAssignedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskAssignedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskAssignedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskAssignedV1 registers the handler of the task_assigned v1 event
+func (d *Dispatcher) OnTaskAssignedV1(handler func(envelope *Envelope, data *TaskAssignedV1) error) {
+	d.handle(TaskAssignedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskAssignedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskAssignedV1) validate(path string) error {
+	return nil
+}
+
+// TaskCancelledV1 is the data of the task_cancelled v1 event, a task has been cancelled
+type TaskCancelledV1 struct {
+	TaskID     uuid.UUID `json:"task_id"`
+	Reason     string    `json:"reason"`
+	PrevStatus string    `json:"prev_status"`
+	// The nil UUID if the task is unassigned
+	PrevAssigneeID uuid.UUID `json:"prev_assignee_id"`
+}
+
+func (*TaskCancelledV1) EventName() string {
+	return TaskCancelledEvent
+}
+
+func (*TaskCancelledV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskCancelledV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskCancelledV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskCancelledV1 registers the handler of the task_cancelled v1 event
+func (d *Dispatcher) OnTaskCancelledV1(handler func(envelope *Envelope, data *TaskCancelledV1) error) {
+	d.handle(TaskCancelledEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskCancelledV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskCancelledV1) validate(path string) error {
+	if err := checkEnum(d.PrevStatus, path+".prev_status", "created", "completed", "cancelled", "unassigned"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TaskCommentAddedV1 is the data of the task_comment_added v1 event, a comment has been added to a task
+type TaskCommentAddedV1 struct {
+	TaskID       uuid.UUID     `json:"task_id"`
+	CommentID    uuid.UUID     `json:"comment_id"`
+	ParentID     uuid.NullUUID `json:"parent_id"`
+	AuthorID     uuid.UUID     `json:"author_id"`
+	Text         string        `json:"text"`
+	MentionedIDs []uuid.UUID   `json:"mentioned_ids"`
+}
+
+func (*TaskCommentAddedV1) EventName() string {
+	return TaskCommentAddedEvent
+}
+
+func (*TaskCommentAddedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskCommentAddedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskCommentAddedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskCommentAddedV1 registers the handler of the task_comment_added v1 event
+func (d *Dispatcher) OnTaskCommentAddedV1(handler func(envelope *Envelope, data *TaskCommentAddedV1) error) {
+	d.handle(TaskCommentAddedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskCommentAddedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskCommentAddedV1) validate(path string) error {
+	if err := checkLength(d.Text, path+".text", 1, -1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TaskCompletedV1 is the data of the task_completed v1 event, a task has been completed
+type TaskCompletedV1 struct {
+	TaskID uuid.UUID `json:"task_id"`
+	// The nil UUID if the task is unassigned
+	AssigneeID uuid.UUID `json:"assignee_id"`
+}
+
+func (*TaskCompletedV1) EventName() string {
+	return TaskCompletedEvent
+}
+
+func (*TaskCompletedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskCompletedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskCompletedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskCompletedV1 registers the handler of the task_completed v1 event
+func (d *Dispatcher) OnTaskCompletedV1(handler func(envelope *Envelope, data *TaskCompletedV1) error) {
+	d.handle(TaskCompletedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskCompletedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskCompletedV1) validate(path string) error {
+	return nil
+}
+
+// TaskCreatedV1 is the data of the task_created v1 event, a task has been created
+type TaskCreatedV1 struct {
+	TaskID      uuid.UUID     `json:"task_id"`
+	ParentID    uuid.NullUUID `json:"parent_id"`
+	BlockedBy   []uuid.UUID   `json:"blocked_by"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Status      string        `json:"status"`
+	// The nil UUID if the task is unassigned
+	AssigneeID uuid.UUID `json:"assignee_id"`
+	Labels     []string  `json:"labels"`
+	Priority   string    `json:"priority"`
+}
+
+func (*TaskCreatedV1) EventName() string {
+	return TaskCreatedEvent
+}
+
+func (*TaskCreatedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskCreatedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskCreatedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskCreatedV1 registers the handler of the task_created v1 event
+func (d *Dispatcher) OnTaskCreatedV1(handler func(envelope *Envelope, data *TaskCreatedV1) error) {
+	d.handle(TaskCreatedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskCreatedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskCreatedV1) validate(path string) error {
+	if err := checkLength(d.Title, path+".title", 1, -1); err != nil {
+		return err
+	}
+	if err := checkEnum(d.Status, path+".status", "created", "completed", "cancelled", "unassigned"); err != nil {
+		return err
+	}
+	if err := checkEnum(d.Priority, path+".priority", "low", "normal", "high", "critical"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpcastTaskCreatedV1 registers the upcaster of the task_created v1 event into v2
+func (d *Dispatcher) UpcastTaskCreatedV1(upcaster func(data *TaskCreatedV1) (*TaskCreatedV2, error)) {
+	d.upcast(TaskCreatedEvent, 1, func(envelope *Envelope) (*Envelope, error) {
+		data := new(TaskCreatedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return nil, err
+		}
+
+		upcast, err := upcaster(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return upcastEnvelope(envelope, upcast)
+	})
+}
+
+// TaskCreatedV2 is the data of the task_created v2 event, a task has been created
+type TaskCreatedV2 struct {
+	TaskID    uuid.UUID     `json:"task_id"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	BlockedBy []uuid.UUID   `json:"blocked_by"`
+	// The title without the Jira ID
+	Title string `json:"title"`
+	// The ID of the Jira issue of the task, if any
+	JiraID      *string `json:"jira_id"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	// The nil UUID if the task is unassigned
+	AssigneeID uuid.UUID `json:"assignee_id"`
+	Labels     []string  `json:"labels"`
+	Priority   string    `json:"priority"`
+}
+
+func (*TaskCreatedV2) EventName() string {
+	return TaskCreatedEvent
+}
+
+func (*TaskCreatedV2) EventVersion() int {
+	return 2
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskCreatedV2) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskCreatedV2) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskCreatedV2 registers the handler of the task_created v2 event
+func (d *Dispatcher) OnTaskCreatedV2(handler func(envelope *Envelope, data *TaskCreatedV2) error) {
+	d.handle(TaskCreatedEvent, 2, func(envelope *Envelope) error {
+		data := new(TaskCreatedV2)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskCreatedV2) validate(path string) error {
+	if err := checkLength(d.Title, path+".title", 1, -1); err != nil {
+		return err
+	}
+	if d.JiraID != nil {
+		if err := checkLength(*d.JiraID, path+".jira_id", 1, -1); err != nil {
+			return err
+		}
+	}
+	if err := checkEnum(d.Status, path+".status", "created", "completed", "cancelled", "unassigned"); err != nil {
+		return err
+	}
+	if err := checkEnum(d.Priority, path+".priority", "low", "normal", "high", "critical"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TaskDependencyAddedV1 is the data of the task_dependency_added v1 event, a task has been blocked by another one
+type TaskDependencyAddedV1 struct {
+	TaskID    uuid.UUID `json:"task_id"`
+	BlockerID uuid.UUID `json:"blocker_id"`
+}
+
+func (*TaskDependencyAddedV1) EventName() string {
+	return TaskDependencyAddedEvent
+}
+
+func (*TaskDependencyAddedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskDependencyAddedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskDependencyAddedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskDependencyAddedV1 registers the handler of the task_dependency_added v1 event
+func (d *Dispatcher) OnTaskDependencyAddedV1(handler func(envelope *Envelope, data *TaskDependencyAddedV1) error) {
+	d.handle(TaskDependencyAddedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskDependencyAddedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskDependencyAddedV1) validate(path string) error {
+	return nil
+}
+
+// TaskDependencyRemovedV1 is the data of the task_dependency_removed v1 event, a task has been unblocked from another one
+type TaskDependencyRemovedV1 struct {
+	TaskID    uuid.UUID `json:"task_id"`
+	BlockerID uuid.UUID `json:"blocker_id"`
+}
+
+func (*TaskDependencyRemovedV1) EventName() string {
+	return TaskDependencyRemovedEvent
+}
+
+func (*TaskDependencyRemovedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskDependencyRemovedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskDependencyRemovedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskDependencyRemovedV1 registers the handler of the task_dependency_removed v1 event
+func (d *Dispatcher) OnTaskDependencyRemovedV1(handler func(envelope *Envelope, data *TaskDependencyRemovedV1) error) {
+	d.handle(TaskDependencyRemovedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskDependencyRemovedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskDependencyRemovedV1) validate(path string) error {
+	return nil
+}
+
+// TaskLabelsUpdatedV1 is the data of the task_labels_updated v1 event, the labels of a task have been changed
+type TaskLabelsUpdatedV1 struct {
+	TaskID     uuid.UUID `json:"task_id"`
+	Labels     []string  `json:"labels"`
+	PrevLabels []string  `json:"prev_labels"`
+}
+
+func (*TaskLabelsUpdatedV1) EventName() string {
+	return TaskLabelsUpdatedEvent
+}
+
+func (*TaskLabelsUpdatedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskLabelsUpdatedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskLabelsUpdatedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskLabelsUpdatedV1 registers the handler of the task_labels_updated v1 event
+func (d *Dispatcher) OnTaskLabelsUpdatedV1(handler func(envelope *Envelope, data *TaskLabelsUpdatedV1) error) {
+	d.handle(TaskLabelsUpdatedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskLabelsUpdatedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskLabelsUpdatedV1) validate(path string) error {
+	return nil
+}
+
+// TaskOverdueV1 is the data of the task_overdue v1 event, a task has passed its due time
+type TaskOverdueV1 struct {
+	TaskID uuid.UUID `json:"task_id"`
+	// The nil UUID if the task is unassigned
+	AssigneeID uuid.UUID `json:"assignee_id"`
+	DueAt      time.Time `json:"due_at"`
+}
+
+func (*TaskOverdueV1) EventName() string {
+	return TaskOverdueEvent
+}
+
+func (*TaskOverdueV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskOverdueV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskOverdueV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskOverdueV1 registers the handler of the task_overdue v1 event
+func (d *Dispatcher) OnTaskOverdueV1(handler func(envelope *Envelope, data *TaskOverdueV1) error) {
+	d.handle(TaskOverdueEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskOverdueV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskOverdueV1) validate(path string) error {
+	return nil
+}
+
+// TaskReopenedV1 is the data of the task_reopened v1 event, a task has been reopened
+type TaskReopenedV1 struct {
+	TaskID     uuid.UUID `json:"task_id"`
+	Reason     string    `json:"reason"`
+	PrevStatus string    `json:"prev_status"`
+	// The nil UUID if the task is unassigned
+	PrevAssigneeID uuid.UUID `json:"prev_assignee_id"`
+	// The nil UUID if the task is unassigned
+	AssigneeID uuid.UUID `json:"assignee_id"`
+}
+
+func (*TaskReopenedV1) EventName() string {
+	return TaskReopenedEvent
+}
+
+func (*TaskReopenedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskReopenedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskReopenedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskReopenedV1 registers the handler of the task_reopened v1 event
+func (d *Dispatcher) OnTaskReopenedV1(handler func(envelope *Envelope, data *TaskReopenedV1) error) {
+	d.handle(TaskReopenedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskReopenedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskReopenedV1) validate(path string) error {
+	if err := checkEnum(d.PrevStatus, path+".prev_status", "created", "completed", "cancelled", "unassigned"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TaskUnassignedV1 is the data of the task_unassigned v1 event, a task has been taken away from its assignee
+type TaskUnassignedV1 struct {
+	TaskID         uuid.UUID `json:"task_id"`
+	PrevAssigneeID uuid.UUID `json:"prev_assignee_id"`
+}
+
+func (*TaskUnassignedV1) EventName() string {
+	return TaskUnassignedEvent
+}
+
+func (*TaskUnassignedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *TaskUnassignedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *TaskUnassignedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnTaskUnassignedV1 registers the handler of the task_unassigned v1 event
+func (d *Dispatcher) OnTaskUnassignedV1(handler func(envelope *Envelope, data *TaskUnassignedV1) error) {
+	d.handle(TaskUnassignedEvent, 1, func(envelope *Envelope) error {
+		data := new(TaskUnassignedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *TaskUnassignedV1) validate(path string) error {
+	return nil
+}
+
+// UserCreatedV1 is the data of the user_created v1 event, a user has signed up
+type UserCreatedV1 struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Skills   []string  `json:"skills"`
+}
+
+func (*UserCreatedV1) EventName() string {
+	return UserCreatedEvent
+}
+
+func (*UserCreatedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *UserCreatedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *UserCreatedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnUserCreatedV1 registers the handler of the user_created v1 event
+func (d *Dispatcher) OnUserCreatedV1(handler func(envelope *Envelope, data *UserCreatedV1) error) {
+	d.handle(UserCreatedEvent, 1, func(envelope *Envelope) error {
+		data := new(UserCreatedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *UserCreatedV1) validate(path string) error {
+	if err := checkLength(d.Username, path+".username", 1, -1); err != nil {
+		return err
+	}
+	if err := checkLength(d.Role, path+".role", 1, -1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UserUpdatedV1 is the data of the user_updated v1 event, a user has changed the profile
+type UserUpdatedV1 struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Skills   []string  `json:"skills"`
+}
+
+func (*UserUpdatedV1) EventName() string {
+	return UserUpdatedEvent
+}
+
+func (*UserUpdatedV1) EventVersion() int {
+	return 1
+}
+
+// Envelope wraps the data into the envelope of the event
+func (d *UserUpdatedV1) Envelope(eventID uuid.UUID, producer string, eventTime time.Time) (*Envelope, error) {
+	return newEnvelope(eventID, producer, eventTime, d)
+}
+
+// Validate checks the data against the constraints of the schema the Go types don't cover
+func (d *UserUpdatedV1) Validate() error {
+	return d.validate("$")
+}
+
+// OnUserUpdatedV1 registers the handler of the user_updated v1 event
+func (d *Dispatcher) OnUserUpdatedV1(handler func(envelope *Envelope, data *UserUpdatedV1) error) {
+	d.handle(UserUpdatedEvent, 1, func(envelope *Envelope) error {
+		data := new(UserUpdatedV1)
+
+		err := decodeData(envelope, data)
+		if err != nil {
+			return err
+		}
+
+		return handler(envelope, data)
+	})
+}
+
+func (d *UserUpdatedV1) validate(path string) error {
+	if err := checkLength(d.Username, path+".username", 1, -1); err != nil {
+		return err
+	}
+	if err := checkLength(d.Role, path+".role", 1, -1); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/vashc/async_arch_course/pkg/events/schema"
+)
+
+// schemaFS is the schema registry, a schema per event and version
+// at schemas/<event_name>/<event_version>.json
+//
+//go:embed schemas
+var schemaFS embed.FS
+
+// Registry validates the events against the schemas of their versions
+type Registry struct {
+	*schema.Registry
+}
+
+// NewRegistry loads the schemas embedded into the package
+func NewRegistry() (*Registry, error) {
+	fsys, err := fs.Sub(schemaFS, "schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := schema.LoadRegistry(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registry{Registry: registry}, nil
+}
+
+// Validate checks the data of the envelope against the schema of its event version
+func (r *Registry) Validate(envelope *Envelope) error {
+	s, err := r.Schema(envelope.EventName, envelope.EventVersion)
+	if err != nil {
+		return err
+	}
+
+	err = s.Validate(envelope.Data)
+	if err != nil {
+		return fmt.Errorf("%s v%d: %w", envelope.EventName, envelope.EventVersion, err)
+	}
+
+	return nil
+}
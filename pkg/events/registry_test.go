@@ -0,0 +1,38 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestRegistryUnassigned makes sure the events of the unassigned tasks, which carry
+// the nil UUID instead of the assignee, pass the schemas
+func TestRegistryUnassigned(t *testing.T) {
+	registry, err := NewRegistry()
+	if err != nil {
+		t.Fatalf("NewRegistry: %s", err.Error())
+	}
+
+	tests := []Data{
+		&TaskOverdueV1{TaskID: uuid.New(), AssigneeID: uuid.Nil, DueAt: time.Now()},
+		&TaskCompletedV1{TaskID: uuid.New(), AssigneeID: uuid.Nil},
+		&TaskCancelledV1{TaskID: uuid.New(), PrevStatus: "unassigned", PrevAssigneeID: uuid.Nil},
+		&TaskReopenedV1{TaskID: uuid.New(), PrevStatus: "cancelled", PrevAssigneeID: uuid.Nil, AssigneeID: uuid.Nil},
+	}
+
+	for _, data := range tests {
+		t.Run(data.EventName(), func(t *testing.T) {
+			envelope, err := newEnvelope(uuid.New(), "task_tracker", time.Now(), data)
+			if err != nil {
+				t.Fatalf("Envelope: %s", err.Error())
+			}
+
+			err = registry.Validate(envelope)
+			if err != nil {
+				t.Errorf("Validate: %s", err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const schemaExt = ".json"
+
+var ErrUnknownSchema = errors.New("event version has no schema")
+
+type Registry struct {
+	schemas map[schemaKey]*Schema
+}
+
+type schemaKey struct {
+	name    string
+	version int
+}
+
+// LoadRegistry loads the schemas of the file system laid out as the registry,
+// a schema per event and version at <event_name>/<event_version>.json
+func LoadRegistry(fsys fs.FS) (*Registry, error) {
+	registry := &Registry{
+		schemas: make(map[schemaKey]*Schema),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		key, err := parseSchemaPath(name)
+		if err != nil {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		schema, err := Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		registry.schemas[key] = schema
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+// Schema gets the schema of the event version
+func (r *Registry) Schema(name string, version int) (*Schema, error) {
+	schema, ok := r.schemas[schemaKey{name: name, version: version}]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnknownSchema, name, version)
+	}
+
+	return schema, nil
+}
+
+// Events lists the events of the registry along with their versions in ascending order
+func (r *Registry) Events() map[string][]int {
+	events := make(map[string][]int)
+	for key := range r.schemas {
+		events[key.name] = append(events[key.name], key.version)
+	}
+
+	for _, versions := range events {
+		sort.Ints(versions)
+	}
+
+	return events
+}
+
+func parseSchemaPath(name string) (schemaKey, error) {
+	dir, file := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+
+	version, err := strconv.Atoi(strings.TrimSuffix(file, schemaExt))
+	if dir == "" || strings.Contains(dir, "/") || !strings.HasSuffix(file, schemaExt) || err != nil || version < 1 {
+		return schemaKey{}, fmt.Errorf("%w: %s is not <event_name>/<event_version>%s", ErrInvalidSchema, name, schemaExt)
+	}
+
+	return schemaKey{name: dir, version: version}, nil
+}
@@ -0,0 +1,273 @@
+// Package schema reads and validates the event schemas of the registry. It holds no generated
+// code, so the generator builds whatever state the generated types are in
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSchema   = errors.New("event schema is malformed")
+	ErrSchemaViolation = errors.New("event data doesn't match its schema")
+)
+
+// uuidRegexp matches the canonical 8-4-4-4-12 form only, the urn, braced and undashed forms
+// are valid UUIDs for the parsers of some consumers and not for the others
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Schema is the subset of JSON Schema the registry is written in. The unknown keywords
+// fail the schema loading, so a schema never relies on a check which isn't made
+type Schema struct {
+	SchemaURI   string `json:"$schema"`
+	ID          string `json:"$id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type      schemaTypes   `json:"type"`
+	Enum      []interface{} `json:"enum"`
+	Format    string        `json:"format"`
+	MinLength *int          `json:"minLength"`
+	MaxLength *int          `json:"maxLength"`
+
+	Properties map[string]*Schema `json:"properties"`
+	Required   []string           `json:"required"`
+	// AdditionalProperties is only a boolean, the properties out of the list are either allowed or not
+	AdditionalProperties *bool `json:"additionalProperties"`
+
+	Items *Schema `json:"items"`
+}
+
+// schemaTypes is the type keyword, which is either a single type or a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*t = multiple
+
+	return nil
+}
+
+// Parse reads the schema, checking its keywords
+func Parse(data []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	schema := new(Schema)
+
+	err := decoder.Decode(schema)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchema, err.Error())
+	}
+
+	err = schema.check("$")
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// Validate checks the JSON document against the schema
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+
+	err := decoder.Decode(&value)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSchemaViolation, err.Error())
+	}
+
+	return s.validate(value, "$")
+}
+
+// check makes sure the keywords have the values the validation understands
+func (s *Schema) check(path string) error {
+	for _, typ := range s.Type {
+		switch typ {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%w: %s has unknown type %q", ErrInvalidSchema, path, typ)
+		}
+	}
+
+	switch s.Format {
+	case "", "uuid", "date-time":
+	default:
+		return fmt.Errorf("%w: %s has unknown format %q", ErrInvalidSchema, path, s.Format)
+	}
+
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("%w: %s requires undefined property %q", ErrInvalidSchema, path, name)
+		}
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%w: %s.%s is empty", ErrInvalidSchema, path, name)
+		}
+
+		err := property.check(path + "." + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+
+	return nil
+}
+
+func (s *Schema) validate(value interface{}, path string) error {
+	if len(s.Type) > 0 && !s.Type.match(value) {
+		return fmt.Errorf("%w: %s is not %s", ErrSchemaViolation, path, strings.Join(s.Type, " or "))
+	}
+
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		return fmt.Errorf("%w: %s is not one of the allowed values", ErrSchemaViolation, path)
+	}
+
+	switch v := value.(type) {
+	case string:
+		return s.validateString(v, path)
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+
+		for i, item := range v {
+			err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateString(value, path string) error {
+	length := len([]rune(value))
+
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		return fmt.Errorf("%w: %s is shorter than %d", ErrSchemaViolation, path, *s.MinLength)
+	case s.MaxLength != nil && length > *s.MaxLength:
+		return fmt.Errorf("%w: %s is longer than %d", ErrSchemaViolation, path, *s.MaxLength)
+	}
+
+	valid := true
+
+	switch s.Format {
+	case "uuid":
+		valid = uuidRegexp.MatchString(value)
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		valid = err == nil
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: %s is not %s", ErrSchemaViolation, path, s.Format)
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(value map[string]interface{}, path string) error {
+	for _, name := range s.Required {
+		if _, ok := value[name]; !ok {
+			return fmt.Errorf("%w: %s.%s is missing", ErrSchemaViolation, path, name)
+		}
+	}
+
+	for name, property := range value {
+		schema, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%w: %s.%s is not allowed", ErrSchemaViolation, path, name)
+			}
+
+			continue
+		}
+
+		err := schema.validate(property, path+"."+name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) inEnum(value interface{}) bool {
+	_, isString := value.(string)
+
+	for _, allowed := range s.Enum {
+		if _, ok := allowed.(string); ok != isString {
+			continue
+		}
+
+		// The numbers are decoded differently in the schema and in the data, so they are compared as text
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t schemaTypes) match(value interface{}) bool {
+	for _, typ := range t {
+		if matchType(typ, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchType(typ string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case json.Number:
+		if typ == "number" {
+			return true
+		}
+
+		_, err := v.Int64()
+		return typ == "integer" && err == nil
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	default:
+		return false
+	}
+}
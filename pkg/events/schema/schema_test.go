@@ -0,0 +1,184 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "format": "uuid"},
+		"created_at": {"type": "string", "format": "date-time"},
+		"title": {"type": "string", "minLength": 1, "maxLength": 5},
+		"status": {"type": "string", "enum": ["open", "done"]},
+		"priority": {"type": "integer", "enum": [1, 2]},
+		"due_at": {"type": ["string", "null"], "format": "date-time"},
+		"labels": {"type": "array", "items": {"type": "string"}}
+	},
+	"required": ["id", "title"],
+	"additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("Parse: %s", err.Error())
+	}
+
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{
+			name:  "valid",
+			data:  `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "status": "open", "priority": 1}`,
+			valid: true,
+		},
+		{
+			name:  "upper case uuid",
+			data:  `{"id": "6BA7B810-9DAD-11D1-80B4-00C04FD430C8", "title": "a"}`,
+			valid: true,
+		},
+		{
+			name:  "nil uuid",
+			data:  `{"id": "00000000-0000-0000-0000-000000000000", "title": "a"}`,
+			valid: true,
+		},
+		{
+			name: "urn uuid",
+			data: `{"id": "urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a"}`,
+		},
+		{
+			name: "braced uuid",
+			data: `{"id": "{6ba7b810-9dad-11d1-80b4-00c04fd430c8}", "title": "a"}`,
+		},
+		{
+			name: "undashed uuid",
+			data: `{"id": "6ba7b8109dad11d180b400c04fd430c8", "title": "a"}`,
+		},
+		{
+			name:  "date-time",
+			data:  `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "created_at": "2024-01-02T03:04:05Z"}`,
+			valid: true,
+		},
+		{
+			name: "malformed date-time",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "created_at": "2024-01-02"}`,
+		},
+		{
+			name:  "null of a nullable type",
+			data:  `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "due_at": null}`,
+			valid: true,
+		},
+		{
+			name: "wrong type",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": 1}`,
+		},
+		{
+			name: "null of a non-nullable type",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": null}`,
+		},
+		{
+			name: "fractional integer",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "priority": 1.5}`,
+		},
+		{
+			name: "wrong item type",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "labels": ["a", 1]}`,
+		},
+		{
+			name: "string out of enum",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "status": "closed"}`,
+		},
+		{
+			name: "number out of enum",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "priority": 3}`,
+		},
+		{
+			name: "too short",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": ""}`,
+		},
+		{
+			name: "too long",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "abcdef"}`,
+		},
+		{
+			name: "missing required",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`,
+		},
+		{
+			name: "additional property",
+			data: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "title": "a", "jira_id": "UBERPOP-42"}`,
+		},
+		{
+			name: "not an object",
+			data: `[]`,
+		},
+		{
+			name: "malformed json",
+			data: `{`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.data))
+
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("Validate: %s", err.Error())
+			case !tt.valid && !errors.Is(err, ErrSchemaViolation):
+				t.Errorf("Validate: got %v, want %v", err, ErrSchemaViolation)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{
+			name:   "valid",
+			schema: testSchema,
+			valid:  true,
+		},
+		{
+			name:   "unknown keyword",
+			schema: `{"type": "object", "patternProperties": {}}`,
+		},
+		{
+			name:   "unknown type",
+			schema: `{"type": "date"}`,
+		},
+		{
+			name:   "unknown format",
+			schema: `{"type": "string", "format": "email"}`,
+		},
+		{
+			name:   "undefined required property",
+			schema: `{"type": "object", "properties": {}, "required": ["id"]}`,
+		},
+		{
+			name:   "non-boolean additionalProperties",
+			schema: `{"type": "object", "additionalProperties": {"type": "string"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.schema))
+
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("Parse: %s", err.Error())
+			case !tt.valid && !errors.Is(err, ErrInvalidSchema):
+				t.Errorf("Parse: got %v, want %v", err, ErrInvalidSchema)
+			}
+		})
+	}
+}
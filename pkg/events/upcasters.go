@@ -0,0 +1,34 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+)
+
+// jiraIDRegexp matches the Jira ID the v1 task titles are prefixed with, e.g. "[UBERPOP-42] Fix the login"
+var jiraIDRegexp = regexp.MustCompile(`^\s*\[([A-Z][A-Z0-9]*-\d+)\]\s*(.*)$`)
+
+// TaskCreatedV1ToV2 moves the Jira ID out of the title, the way the producers of v2 do.
+// The consumers of v2 register it as the upcaster of task_created v1
+func TaskCreatedV1ToV2(data *TaskCreatedV1) (*TaskCreatedV2, error) {
+	upcast := &TaskCreatedV2{
+		TaskID:      data.TaskID,
+		ParentID:    data.ParentID,
+		BlockedBy:   data.BlockedBy,
+		Title:       data.Title,
+		Description: data.Description,
+		Status:      data.Status,
+		AssigneeID:  data.AssigneeID,
+		Labels:      data.Labels,
+		Priority:    data.Priority,
+	}
+
+	match := jiraIDRegexp.FindStringSubmatch(data.Title)
+	// A title of the Jira ID alone is kept as it is, the title can't be empty
+	if match != nil && strings.TrimSpace(match[2]) != "" {
+		upcast.JiraID = &match[1]
+		upcast.Title = strings.TrimSpace(match[2])
+	}
+
+	return upcast, nil
+}
@@ -1,68 +1,148 @@
 package internal
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/streadway/amqp"
+	"github.com/vashc/async_arch_course/pkg/events"
 )
 
+// NewClient connects to the event bus and keeps the connection up from then on. Once the connection
+// is lost, it's restored with a backoff and the declared topology is declared once again
 func NewClient(config *Config) (*RabbitClient, error) {
-	conn, err := amqp.Dial(config.EventBus.uri())
+	registry, err := events.NewRegistry()
 	if err != nil {
 		return nil, err
 	}
 
-	ch, err := conn.Channel()
+	client := &RabbitClient{
+		config:   &config.EventBus,
+		registry: registry,
+		state:    RabbitReconnecting,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	connClosed, chClosed, err := client.connect()
 	if err != nil {
 		return nil, err
 	}
 
-	return &RabbitClient{
-		conn,
-		ch,
-	}, nil
+	err = client.declare(declareExchange)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	go client.supervise(connClosed, chClosed)
+
+	return client, nil
 }
 
 func (c *RabbitClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+
+	close(c.done)
+	c.state = RabbitClosed
+
+	if c.conn == nil {
+		return nil
+	}
+
 	_ = c.ch.Close()
 	return c.conn.Close()
 }
 
+// State tells whether the client is connected to the event bus
+func (c *RabbitClient) State() RabbitState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.state
+}
+
 func (c *RabbitClient) CreateQueue(routingKey string) error {
-	// Declaring a queue is idempotent
-	_, err := c.ch.QueueDeclare(
-		routingKey,
-		RabbitDurable,
-		RabbitAutoDelete,
-		RabbitExclusive,
-		RabbitNoWait,
-		nil,
-	)
+	return c.declare(func(ch *amqp.Channel) error {
+		// Declaring a queue is idempotent
+		_, err := ch.QueueDeclare(
+			routingKey,
+			RabbitDurable,
+			RabbitAutoDelete,
+			RabbitExclusive,
+			RabbitNoWait,
+			nil,
+		)
 
-	return err
+		return err
+	})
 }
 
-func (c *RabbitClient) Publish(routingKey string, msg interface{}) error {
-	body, err := json.Marshal(msg)
+// Publish sends the event wrapped into the envelope, once its data matches the schema.
+// While the event bus is down, the call waits for the connection up to the publish timeout
+func (c *RabbitClient) Publish(routingKey string, envelope *events.Envelope) error {
+	msg, err := envelope.Publishing()
 	if err != nil {
 		return err
 	}
 
-	return c.ch.Publish(
-		RabbitExchange,
-		routingKey,
-		RabbitMandatory,
-		RabbitImmediate,
-		amqp.Publishing{
-			Type:        userCreatedEventType,
-			ContentType: RabbitContentType,
-			Body:        body,
-		},
-	)
+	err = c.registry.Validate(envelope)
+	if err != nil {
+		return err
+	}
+
+	return c.publish(RabbitExchange, routingKey, msg)
 }
 
+// Listen consumes the queue until the client is closed. The deliveries stop while the event bus
+// is down and the consumer is resumed after the reconnection. The messages delivered before it
+// can't be acked anymore, the broker redelivers them
 func (c *RabbitClient) Listen(queueName string) (<-chan amqp.Delivery, error) {
-	return c.ch.Consume(
+	deliveries, err := c.consume(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp.Delivery)
+
+	go func() {
+		defer close(out)
+
+		for {
+			for msg := range deliveries {
+				select {
+				case out <- msg:
+				case <-c.done:
+					return
+				}
+			}
+
+			deliveries, err = c.resume(queueName)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (c *RabbitClient) consume(queueName string) (<-chan amqp.Delivery, error) {
+	ch, err := c.channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return ch.Consume(
 		queueName,
 		RabbitConsumer,
 		RabbitAutoAck,
@@ -72,3 +152,285 @@ func (c *RabbitClient) Listen(queueName string) (<-chan amqp.Delivery, error) {
 		nil,
 	)
 }
+
+// resume consumes the queue again once the connection is back, it fails only if the client is closed
+func (c *RabbitClient) resume(queueName string) (<-chan amqp.Delivery, error) {
+	for {
+		deliveries, err := c.consume(queueName)
+		switch {
+		case err == nil:
+			return deliveries, nil
+		case errors.Is(err, ErrEventBusClosed):
+			return nil, err
+		case !errors.Is(err, ErrEventBusUnavailable):
+			log.Printf("rabbitClient.consume error: %s\n", err.Error())
+
+			select {
+			case <-time.After(c.config.ReconnectBackoff):
+			case <-c.done:
+				return nil, ErrEventBusClosed
+			}
+		}
+	}
+}
+
+// declare applies the topology and keeps it to be applied again after a reconnection
+func (c *RabbitClient) declare(fn func(ch *amqp.Channel) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.topology = append(c.topology, fn)
+
+	// The topology is applied as soon as the connection is back
+	if c.ch == nil {
+		return nil
+	}
+
+	return fn(c.ch.Channel)
+}
+
+// publish sends the message and waits for the broker to confirm it. A message which isn't routed
+// to any queue is returned by the broker ahead of the confirm, then the publish fails as well
+func (c *RabbitClient) publish(exchange, routingKey string, msg amqp.Publishing) error {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	ch, err := c.channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Publish(exchange, routingKey, RabbitMandatory, RabbitImmediate, msg)
+	if err != nil {
+		return err
+	}
+
+	ch.published++
+
+	timer := time.NewTimer(c.config.ConfirmTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case confirm, ok := <-ch.confirms:
+			if !ok {
+				return ErrEventBusUnavailable
+			}
+
+			// The confirms of the messages which have timed out come late, they are skipped
+			if confirm.DeliveryTag < ch.published {
+				continue
+			}
+
+			if !confirm.Ack {
+				return fmt.Errorf("%w: %s", ErrPublishNacked, msg.MessageId)
+			}
+
+			if returned := ch.returned(msg.MessageId); returned != nil {
+				return fmt.Errorf("%w: %s %s", ErrPublishReturned, returned.ReplyText, msg.MessageId)
+			}
+
+			return nil
+		case <-timer.C:
+			return fmt.Errorf("%w: %s", ErrConfirmTimeout, msg.MessageId)
+		}
+	}
+}
+
+// returned drains the returned messages, telling whether the message is among them
+func (ch *rabbitChannel) returned(messageID string) *amqp.Return {
+	var returned *amqp.Return
+
+	for {
+		select {
+		case msg, ok := <-ch.returns:
+			if !ok {
+				return returned
+			}
+
+			if msg.MessageId == messageID {
+				returned = &msg
+			}
+		default:
+			return returned
+		}
+	}
+}
+
+// channel waits for the connection up to the publish timeout and returns its channel
+func (c *RabbitClient) channel() (*rabbitChannel, error) {
+	c.mu.RLock()
+	ready := c.ready
+	c.mu.RUnlock()
+
+	timer := time.NewTimer(c.config.PublishTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+	case <-c.done:
+		return nil, ErrEventBusClosed
+	case <-timer.C:
+		return nil, ErrEventBusUnavailable
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// The connection may be lost again in the meantime
+	if c.ch == nil {
+		return nil, ErrEventBusUnavailable
+	}
+
+	return c.ch, nil
+}
+
+// connect dials the event bus and applies the topology, the returned channels are notified
+// once the connection or its channel is closed
+func (c *RabbitClient) connect() (connClosed, chClosed chan *amqp.Error, err error) {
+	conn, err := amqp.Dial(c.config.uri())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed = ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	err = ch.Confirm(RabbitNoWait)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	// The library blocks on full notification channels, so they are buffered for the late ones
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, RabbitNotifyBuffer))
+	returns := ch.NotifyReturn(make(chan amqp.Return, RabbitNotifyBuffer))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		_ = conn.Close()
+		return nil, nil, ErrEventBusClosed
+	default:
+	}
+
+	for _, fn := range c.topology {
+		err = fn(ch)
+		if err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	c.conn = conn
+	c.ch = &rabbitChannel{
+		Channel:  ch,
+		confirms: confirms,
+		returns:  returns,
+	}
+	c.state = RabbitConnected
+	close(c.ready)
+
+	return connClosed, chClosed, nil
+}
+
+// supervise reconnects whenever the connection or its channel is closed, until the client is closed.
+// A closed channel takes down the whole connection, so there's a single way to recover
+func (c *RabbitClient) supervise(connClosed, chClosed chan *amqp.Error) {
+	for {
+		var amqpErr *amqp.Error
+
+		select {
+		case <-c.done:
+			return
+		case amqpErr = <-connClosed:
+		case amqpErr = <-chClosed:
+		}
+
+		if !c.disconnect() {
+			return
+		}
+
+		log.Printf("Event bus connection is lost: %v, reconnecting\n", amqpErr)
+
+		connClosed, chClosed = c.reconnect()
+		if connClosed == nil {
+			return
+		}
+
+		log.Println("Event bus connection is restored")
+	}
+}
+
+// disconnect drops the lost connection, so the callers wait for a new one.
+// It returns false if the client has been closed
+func (c *RabbitClient) disconnect() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+
+	c.conn, c.ch = nil, nil
+	c.state = RabbitReconnecting
+	c.ready = make(chan struct{})
+
+	return true
+}
+
+// reconnect dials the event bus until it succeeds, doubling the delay between the attempts.
+// It returns nil channels if the client is closed in the meantime
+func (c *RabbitClient) reconnect() (connClosed, chClosed chan *amqp.Error) {
+	delay := c.config.ReconnectBackoff
+
+	for {
+		select {
+		case <-c.done:
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		connClosed, chClosed, err := c.connect()
+		if err == nil {
+			return connClosed, chClosed
+		}
+
+		if errors.Is(err, ErrEventBusClosed) {
+			return nil, nil
+		}
+
+		log.Printf("rabbitClient.connect error: %s\n", err.Error())
+
+		delay *= 2
+		if delay > c.config.MaxReconnectBackoff {
+			delay = c.config.MaxReconnectBackoff
+		}
+	}
+}
+
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		RabbitExchange,
+		RabbitExchangeKind,
+		RabbitDurable,
+		RabbitAutoDelete,
+		RabbitInternal,
+		RabbitNoWait,
+		nil,
+	)
+}
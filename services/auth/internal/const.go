@@ -6,6 +6,9 @@ const (
 	authTokenAlgo = "HS256"
 
 	requestParamUserID = "user_id"
+
+	// outboxChannel is notified by the database whenever new outbox events are committed
+	outboxChannel = "outbox"
 )
 
 const (
@@ -16,24 +19,38 @@ const (
 type Role string
 
 const (
-	_ Role = "user"
-	_ Role = "accountant"
-	_ Role = "admin"
+	_         Role = "user"
+	_         Role = "accountant"
+	adminRole Role = "admin"
+)
+
+const (
+	RabbitProtocol   = "amqp"
+	RabbitDurable    = true
+	RabbitAutoDelete = false
+	RabbitExclusive  = false
+	RabbitNoWait     = false
+	RabbitNoLocal    = false
+	RabbitExchange   = "auth.out"
+	// RabbitExchangeKind and RabbitInternal have to match the exchange in the event bus definitions
+	RabbitExchangeKind = "fanout"
+	RabbitInternal     = false
+	RabbitMandatory    = true
+	RabbitImmediate    = false
+	RabbitConsumer     = ""
+	RabbitAutoAck      = false
+	RabbitNotifyBuffer = 16
 )
 
+type RabbitState string
+
 const (
-	RabbitProtocol    = "amqp"
-	RabbitDurable     = true
-	RabbitAutoDelete  = false
-	RabbitExclusive   = false
-	RabbitNoWait      = false
-	RabbitNoLocal     = false
-	RabbitExchange    = "auth.out"
-	RabbitMandatory   = false
-	RabbitImmediate   = false
-	RabbitContentType = "text/plain"
-	RabbitConsumer    = ""
-	RabbitAutoAck     = true
+	RabbitConnected    RabbitState = "connected"
+	RabbitReconnecting RabbitState = "reconnecting"
+	RabbitClosed       RabbitState = "closed"
 )
 
-const userCreatedEventType = "user_created"
+// eventProducer names the service in the envelopes of its events
+const eventProducer = "auth"
+
+type EventType string
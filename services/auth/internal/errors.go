@@ -12,4 +12,10 @@ var (
 var (
 	ErrUnsupportedMediaType = errors.New("Content-Type header is not application/json")
 	ErrRequestBodyDeconding = errors.New("request body contains badly formed JSON")
+	ErrEventBusUnavailable  = errors.New("event bus connection is down")
+	ErrEventBusClosed       = errors.New("event bus client is closed")
+	ErrPublishNacked        = errors.New("event bus has rejected the message")
+	ErrPublishReturned      = errors.New("message isn't routed to any queue")
+	ErrConfirmTimeout       = errors.New("event bus hasn't confirmed the message in time")
+	ErrInvalidTokenClaims   = errors.New("auth token has no valid user_id claim")
 )
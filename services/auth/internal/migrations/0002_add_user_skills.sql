@@ -0,0 +1,8 @@
+-- +goose Up
+
+ALTER TABLE users
+    ADD COLUMN skills TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN skills;
@@ -0,0 +1,37 @@
+-- +goose Up
+
+-- Events are stored in the transaction of the change they tell about,
+-- the relay publishes them to the event bus afterwards
+CREATE TABLE outbox (
+    id              BIGSERIAL   NOT NULL PRIMARY KEY,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    event_type      VARCHAR(50) NOT NULL,
+    payload         JSONB       NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT        NOT NULL DEFAULT '',
+    sent_at         TIMESTAMPTZ
+);
+
+CREATE INDEX idx_outbox_pending ON outbox(next_attempt_at, id) WHERE sent_at IS NULL;
+CREATE INDEX idx_outbox_sent_at ON outbox(sent_at) WHERE sent_at IS NOT NULL;
+
+-- Wake the relay up once the events are committed, the notifications of a transaction are folded into one
+-- +goose StatementBegin
+CREATE FUNCTION outbox_notify_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('outbox', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_outbox_notify
+    AFTER INSERT ON outbox
+    FOR EACH STATEMENT EXECUTE FUNCTION outbox_notify_trigger();
+
+-- +goose Down
+DROP TRIGGER trg_outbox_notify ON outbox;
+DROP FUNCTION outbox_notify_trigger();
+DROP TABLE outbox;
@@ -0,0 +1,13 @@
+-- +goose Up
+
+-- Consumers deduplicate the redelivered events by their IDs
+ALTER TABLE outbox
+    ADD COLUMN event_id UUID NOT NULL DEFAULT uuid_generate_v4();
+
+CREATE UNIQUE INDEX idx_outbox_event_id ON outbox(event_id);
+
+-- +goose Down
+DROP INDEX idx_outbox_event_id;
+
+ALTER TABLE outbox
+    DROP COLUMN event_id;
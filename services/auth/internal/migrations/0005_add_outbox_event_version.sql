@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- The events are published in the schema version they were stored in,
+-- the ones stored before the versions were tracked are of the first one
+ALTER TABLE outbox
+    ADD COLUMN event_version INT NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE outbox
+    DROP COLUMN event_version;
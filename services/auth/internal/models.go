@@ -3,12 +3,15 @@ package internal
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gocraft/dbr/v2"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/streadway/amqp"
+	"github.com/vashc/async_arch_course/pkg/events"
 )
 
 type Service struct {
@@ -20,6 +23,13 @@ type Service struct {
 	*chi.Mux
 }
 
+// Relay publishes the outbox events to the event bus
+type Relay struct {
+	config  *Config
+	storage *Storage
+	client  *RabbitClient
+}
+
 type Storage struct {
 	sess *dbr.Session
 }
@@ -48,6 +58,14 @@ type RabbitConfig struct {
 	RabbitPort  string `envconfig:"RABBIT_PORT" required:"true" default:"5672"`
 	RabbitLogin string `envconfig:"RABBIT_LOGIN" required:"true" default:"user"`
 	RabbitPass  string `envconfig:"RABBIT_PASS" required:"true" default:"pass"`
+
+	// ReconnectBackoff is the delay of the first reconnection, it's doubled up to MaxReconnectBackoff
+	ReconnectBackoff    time.Duration `envconfig:"RABBIT_RECONNECT_BACKOFF" required:"true" default:"1s"`
+	MaxReconnectBackoff time.Duration `envconfig:"RABBIT_MAX_RECONNECT_BACKOFF" required:"true" default:"30s"`
+	// PublishTimeout bounds the wait for the connection while the event bus is down
+	PublishTimeout time.Duration `envconfig:"RABBIT_PUBLISH_TIMEOUT" required:"true" default:"5s"`
+	// ConfirmTimeout bounds the wait for the broker to confirm a published message
+	ConfirmTimeout time.Duration `envconfig:"RABBIT_CONFIRM_TIMEOUT" required:"true" default:"5s"`
 }
 
 func (rc *RabbitConfig) uri() string {
@@ -66,10 +84,24 @@ type API struct {
 	Port string `envconfig:"AUTH_PORT" required:"true" default:"8000"`
 }
 
+type OutboxConfig struct {
+	// RelayInterval is how often the relay looks for pending events it hasn't been woken up for
+	RelayInterval time.Duration `envconfig:"OUTBOX_RELAY_INTERVAL" required:"true" default:"5s"`
+	BatchSize     uint64        `envconfig:"OUTBOX_BATCH_SIZE" required:"true" default:"100"`
+	// RetryBackoff is doubled with every failed attempt up to MaxBackoff
+	RetryBackoff time.Duration `envconfig:"OUTBOX_RETRY_BACKOFF" required:"true" default:"1s"`
+	MaxBackoff   time.Duration `envconfig:"OUTBOX_MAX_BACKOFF" required:"true" default:"5m"`
+	// ClaimLease is how long a batch is held by a relay, it has to outlast the publishing of the batch
+	ClaimLease    time.Duration `envconfig:"OUTBOX_CLAIM_LEASE" required:"true" default:"1m"`
+	Retention     time.Duration `envconfig:"OUTBOX_RETENTION" required:"true" default:"72h"`
+	PruneInterval time.Duration `envconfig:"OUTBOX_PRUNE_INTERVAL" required:"true" default:"1h"`
+}
+
 type Config struct {
 	DB       DB
 	EventBus RabbitConfig
 	API      API
+	Outbox   OutboxConfig
 
 	JWTSecret string `envconfig:"JWT_SECRET" required:"true" default:"some_default_jwt_secret"`
 }
@@ -83,22 +115,72 @@ type AuthRequest struct {
 	Password string `json:"password"`
 }
 
+type ProfileRequest struct {
+	Skills []string `json:"skills"`
+}
+
 type Response struct {
 	Status string `json:"status"`
 }
 
+type HealthResponse struct {
+	Status   string      `json:"status"`
+	EventBus RabbitState `json:"event_bus"`
+}
+
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	Role      Role      `json:"role"`
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Username  string         `json:"username"`
+	Password  string         `json:"password"`
+	Role      Role           `json:"role"`
+	Skills    pq.StringArray `json:"skills"`
+}
+
+// OutboxEvent is an event stored along with the change it tells about. The relay
+// publishes it afterwards, so an event bus outage doesn't lose the event
+type OutboxEvent struct {
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	EventID       uuid.UUID  `json:"event_id"`
+	CorrelationID uuid.UUID  `json:"correlation_id"`
+	EventType     EventType  `json:"event_type"`
+	EventVersion  int        `json:"event_version"`
+	Payload       []byte     `json:"payload"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error"`
+	SentAt        *time.Time `json:"sent_at"`
 }
 
+// RabbitClient keeps a single connection to the event bus, which is replaced whenever it's lost
 type RabbitClient struct {
-	conn *amqp.Connection
-	ch   *amqp.Channel
+	config *RabbitConfig
+	// registry keeps the schemas the events are validated against
+	registry *events.Registry
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ch    *rabbitChannel
+	state RabbitState
+	// ready is closed once the client is connected, a new one is made on a disconnection
+	ready chan struct{}
+	// topology is declared on every connection
+	topology []func(ch *amqp.Channel) error
+	done     chan struct{}
+	// publishMu keeps a single message waiting for the confirm at a time
+	publishMu sync.Mutex
+}
+
+// rabbitChannel is the channel of the connection in the confirm mode
+type rabbitChannel struct {
+	*amqp.Channel
+
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	// published is the delivery tag of the last published message
+	published uint64
 }
 
 type AuthToken map[string]interface{}
@@ -47,6 +47,16 @@ func (s *Service) InstantiateRoutes() {
 			fmt.Sprintf("/{%s}", requestParamUserID),
 			s.getUserHandler(),
 		)
+		// Skills drive the task assignment, so only the user themselves or an admin may change them
+		router.Group(func(router chi.Router) {
+			tokenAuth := jwtauth.New(authTokenAlgo, []byte(s.config.JWTSecret), nil)
+			router.Use(jwtauth.Verifier(tokenAuth), jwtauth.Authenticator(tokenAuth))
+
+			router.Put(
+				fmt.Sprintf("/{%s}/profile", requestParamUserID),
+				s.updateProfileHandler(),
+			)
+		})
 		router.Post("/create", s.createUserHandler())
 		router.Post("/auth", s.authUserHandler())
 	})
@@ -83,6 +93,8 @@ func (s *Service) createUserHandler() func(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
+		user.Skills = NormalizeTags(user.Skills)
+
 		if err = s.storage.CreateUser(user); err != nil {
 			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
@@ -96,18 +108,6 @@ func (s *Service) createUserHandler() func(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		// Create exchange message in a queue
-		userCreated := UserCreatedOut{
-			ID:       user.ID,
-			Username: user.Username,
-			Role:     user.Role,
-		}
-
-		err = s.client.Publish("", userCreated)
-		if err != nil {
-			log.Printf("client.Publish: %s\n", err.Error())
-		}
-
 		_, _ = w.Write(resp)
 	}
 }
@@ -195,8 +195,62 @@ func (s *Service) getUserHandler() func(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (s *Service) healthHandler() func(w http.ResponseWriter, r *http.Request) {
+func (s *Service) updateProfileHandler() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(chi.URLParam(r, requestParamUserID))
+		if err != nil {
+			log.Printf("uuid.Parse: %s\n", err.Error())
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		actorID, err := tokenUserID(r)
+		if err != nil {
+			log.Printf("tokenUserID: %s\n", err.Error())
+			code := http.StatusUnauthorized
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if actorID != userID {
+			var actor *User
+
+			actor, err = s.storage.GetUserByID(actorID)
+			if err != nil && !errors.Is(err, dbr.ErrNotFound) {
+				log.Printf("storage.GetUserByID: %s\n", err.Error())
+				code := http.StatusInternalServerError
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			if actor == nil || actor.Role != adminRole {
+				code := http.StatusForbidden
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+		}
+
+		req := new(ProfileRequest)
+
+		err = BodyParser(w, r, req)
+		if err != nil {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, err = s.storage.UpdateUserSkills(userID, NormalizeTags(req.Skills))
+		if err != nil {
+			log.Printf("storage.UpdateUserSkills: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			if errors.Is(err, dbr.ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
 		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
 		if err != nil {
 			code := http.StatusInternalServerError
@@ -207,3 +261,26 @@ func (s *Service) healthHandler() func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write(resp)
 	}
 }
+
+// healthHandler reports the event bus connection along with the service status. The service
+// is unavailable while the event bus is down, even though the events wait in the outbox
+func (s *Service) healthHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusOK
+
+		state := s.client.State()
+		if state != RabbitConnected {
+			code = http.StatusServiceUnavailable
+		}
+
+		resp, err := json.Marshal(HealthResponse{Status: http.StatusText(code), EventBus: state})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		w.WriteHeader(code)
+		_, _ = w.Write(resp)
+	}
+}
@@ -2,13 +2,17 @@ package internal
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/gocraft/dbr/v2"
 	"github.com/google/uuid"
-	_ "github.com/lib/pq" // Driver
+	"github.com/lib/pq" // Driver
 	"github.com/pressly/goose/v3"
+	"github.com/vashc/async_arch_course/pkg/events"
 )
 
 //go:embed migrations
@@ -61,8 +65,8 @@ func (s *Storage) Close() error {
 
 func (s *Storage) CreateUser(user *User) error {
 	query := `
-INSERT INTO users(username, password, role)
-VALUES (?, ?, ?)
+INSERT INTO users(username, password, role, skills)
+VALUES (?, ?, ?, ?)
 RETURNING id;
 `
 
@@ -77,11 +81,25 @@ RETURNING id;
 		user.Username,
 		user.Password,
 		user.Role,
+		user.Skills,
 	).Load(user)
 	if err != nil {
 		return err
 	}
 
+	// Create exchange message in a queue
+	userCreated := &events.UserCreatedV1{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     string(user.Role),
+		Skills:   user.Skills,
+	}
+
+	err = insertOutboxEvent(tx, userCreated)
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -126,3 +144,209 @@ WHERE id = ?;
 
 	return user, nil
 }
+
+func (s *Storage) UpdateUserSkills(id uuid.UUID, skills []string) (user *User, err error) {
+	query := `
+UPDATE users
+SET skills = ?, updated_at = now()
+WHERE id = ?
+RETURNING *;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.SelectBySql(query, pq.StringArray(skills), id).LoadOne(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create exchange message in a queue
+	userUpdated := &events.UserUpdatedV1{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     string(user.Role),
+		Skills:   user.Skills,
+	}
+
+	err = insertOutboxEvent(tx, userUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+// insertOutboxEvent stores the event in the transaction of the change it tells about.
+// The events stored by one transaction share the correlation ID, which is kept
+// in a setting local to the transaction
+func insertOutboxEvent(tx *dbr.Tx, data events.Data) error {
+	query := `
+WITH correlation AS (
+    SELECT set_config(
+        'outbox.correlation_id',
+        COALESCE(NULLIF(current_setting('outbox.correlation_id', true), ''), ?),
+        true
+    ) AS id
+)
+INSERT INTO outbox(event_type, event_version, payload, correlation_id)
+SELECT ?, ?, ?, id::UUID
+FROM correlation;
+`
+
+	err := data.Validate()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// The payload goes as a string, a byte slice would be interpolated as bytea
+	_, err = tx.InsertBySql(
+		query,
+		uuid.New().String(),
+		data.EventName(),
+		data.EventVersion(),
+		string(payload),
+	).Exec()
+
+	return err
+}
+
+// RelayOutboxEvents hands the pending events to publish. The batch is claimed for the lease
+// in a short transaction, so the relays of the other replicas skip it and no transaction stays
+// open while the events are published. The events go out by ID, yet a failed event is retried
+// after the backoff while the later ones are not held back, so the consumers can't rely on the
+// order. The first failed event stops the batch, the rest of the batch is released right away.
+// An event may be published twice if the lease runs out, the consumers deduplicate it by its ID
+func (s *Storage) RelayOutboxEvents(
+	limit uint64,
+	lease time.Duration,
+	backoff func(attempts int) time.Duration,
+	publish func(*OutboxEvent) error,
+) (sent int, err error) {
+	claimQuery := `
+UPDATE outbox
+SET next_attempt_at = now() + make_interval(secs => ?)
+WHERE id IN (
+    SELECT id
+    FROM outbox
+    WHERE sent_at IS NULL AND next_attempt_at <= now()
+    ORDER BY id
+    LIMIT ?
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+`
+
+	sentQuery := `
+UPDATE outbox
+SET sent_at = now(), attempts = attempts + 1, last_error = ''
+WHERE id = ?;
+`
+
+	failedQuery := `
+UPDATE outbox
+SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?
+WHERE id = ?;
+`
+
+	releaseQuery := `
+UPDATE outbox
+SET next_attempt_at = now()
+WHERE id IN ? AND sent_at IS NULL;
+`
+
+	events, err := s.claimOutboxEvents(claimQuery, lease, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		publishErr := publish(event)
+		if publishErr != nil {
+			log.Printf("outbox event %d failed to publish: %s\n", event.ID, publishErr.Error())
+
+			nextAttemptAt := time.Now().Add(backoff(event.Attempts + 1))
+
+			_, err = s.sess.UpdateBySql(failedQuery, nextAttemptAt, publishErr.Error(), event.ID).Exec()
+			if err != nil {
+				return sent, err
+			}
+
+			rest := make([]int64, 0, len(events)-i-1)
+			for _, unsent := range events[i+1:] {
+				rest = append(rest, unsent.ID)
+			}
+
+			if len(rest) > 0 {
+				_, err = s.sess.UpdateBySql(releaseQuery, rest).Exec()
+			}
+
+			return sent, err
+		}
+
+		_, err = s.sess.UpdateBySql(sentQuery, event.ID).Exec()
+		if err != nil {
+			return sent, err
+		}
+
+		sent++
+	}
+
+	return sent, nil
+}
+
+func (s *Storage) claimOutboxEvents(query string, lease time.Duration, limit uint64) ([]*OutboxEvent, error) {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	events := make([]*OutboxEvent, 0)
+
+	_, err = tx.SelectBySql(query, lease.Seconds(), limit).Load(&events)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING keeps no order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, tx.Commit()
+}
+
+// PruneOutboxEvents deletes the events which have been sent before the time
+func (s *Storage) PruneOutboxEvents(before time.Time) (int64, error) {
+	query := `
+DELETE FROM outbox
+WHERE sent_at < ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.DeleteBySql(query, before).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
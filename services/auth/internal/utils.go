@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
 )
 
 func BodyParser(w http.ResponseWriter, r *http.Request, dst interface{}) error {
@@ -24,3 +28,41 @@ func BodyParser(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 
 	return nil
 }
+
+// NormalizeTags lowercases and trims the tags dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+// tokenUserID gets the ID of the user the verified auth token of the request is issued to
+func tokenUserID(r *http.Request) (uuid.UUID, error) {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	value, ok := claims[requestParamUserID].(string)
+	if !ok {
+		return uuid.Nil, ErrInvalidTokenClaims
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %s", ErrInvalidTokenClaims, err.Error())
+	}
+
+	return id, nil
+}
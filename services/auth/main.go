@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
@@ -37,6 +38,16 @@ func main() {
 	// Instantiate routes
 	service.InstantiateRoutes()
 
+	// Start outbox relay, it publishes the events stored along with the changes
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := auth.NewRelay(config, storage, client)
+	go func() {
+		err := relay.Process(ctx)
+		if err != nil {
+			log.Fatalf("relay.Process error: %s", err.Error())
+		}
+	}()
+
 	// Handle graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -44,7 +55,8 @@ func main() {
 	go func() {
 		<-c
 		log.Println("Gracefully shutting down")
-		if err = service.Stop(); err != nil {
+		cancel()
+		if err := service.Stop(); err != nil {
 			log.Fatalf("service.Stop error: %s", err.Error())
 		}
 	}()
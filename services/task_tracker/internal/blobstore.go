@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	blobBackendLocal = "local"
+	blobBackendS3    = "s3"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3Service        = "s3"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3DateFormat     = "20060102"
+	s3DateTimeFormat = "20060102T150405Z"
+)
+
+// NewBlobStore creates the blob store the config asks for
+func NewBlobStore(config *Config) (BlobStore, error) {
+	switch config.Blob.Backend {
+	case blobBackendLocal:
+		return NewLocalBlobStore(config.Blob.LocalDir)
+	case blobBackendS3:
+		return NewS3BlobStore(&config.Blob), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlobBackend, config.Blob.Backend)
+	}
+}
+
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(root, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalBlobStore{root: root}, nil
+}
+
+func (s *LocalBlobStore) Put(_ context.Context, key string, body io.Reader, _ int64, _ string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+
+	// Write into a temporary file first, so a half-written blob is never visible
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalBlobStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+
+	return file, err
+}
+
+func (s *LocalBlobStore) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path maps a key onto the file system, keys must not escape the root directory
+func (s *LocalBlobStore) path(key string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, s.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidBlobKey, key)
+	}
+
+	return path, nil
+}
+
+func NewS3BlobStore(config *BlobConfig) *S3BlobStore {
+	return &S3BlobStore{
+		config: config,
+		client: &http.Client{
+			Timeout: config.S3Timeout,
+		},
+	}
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// request builds a path-style request to the bucket, so that S3-compatible
+// storages like MinIO work without any DNS set up
+func (s *S3BlobStore) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	endpoint, err := url.Parse(s.config.S3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Path = "/" + s.config.S3Bucket + "/" + strings.TrimPrefix(key, "/")
+	endpoint.RawPath = s3EscapePath(endpoint.Path)
+
+	return http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+}
+
+func (s *S3BlobStore) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrBlobNotFound
+		}
+
+		// S3 errors are short XML documents, they are worth logging as is
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+
+		return nil, fmt.Errorf("%w: %s %s", ErrBlobStoreRequest, resp.Status, message)
+	}
+
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request. The payload is
+// not hashed, which S3 allows with the UNSIGNED-PAYLOAD content hash
+func (s *S3BlobStore) sign(req *http.Request, now time.Time) {
+	date := now.Format(s3DateFormat)
+	payloadHash := s3UnsignedBody
+
+	if req.Body == nil {
+		emptyHash := sha256.Sum256(nil)
+		payloadHash = hex.EncodeToString(emptyHash[:])
+	}
+
+	req.Header.Set("X-Amz-Date", now.Format(s3DateTimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headerNames := []string{"host"}
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := req.URL.Host
+		if name != "host" {
+			value = req.Header.Get(name)
+		}
+
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.config.S3Region, s3Service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3DateTimeFormat),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.S3SecretKey), date)
+	key = hmacSHA256(key, s.config.S3Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm,
+		s.config.S3AccessKey,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery renders the query sorted by names and values, encoded the way SigV4 expects.
+// Unlike url.Values.Encode, spaces become %20 rather than +, and the slashes are encoded too
+func s3CanonicalQuery(query url.Values) string {
+	params := make([][2]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, [2]string{s3Escape(name, false), s3Escape(value, false)})
+		}
+	}
+
+	// The names are compared on their own, "a=" goes before "a-b=" even though "=" is greater than "-"
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+
+		return params[i][1] < params[j][1]
+	})
+
+	encoded := make([]string, 0, len(params))
+	for _, param := range params {
+		encoded = append(encoded, param[0]+"="+param[1])
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// s3EscapePath encodes the path as SigV4 expects, leaving only
+// the unreserved characters and the segment separators as they are
+func s3EscapePath(path string) string {
+	return s3Escape(path, true)
+}
+
+func s3Escape(value string, keepSlash bool) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			escaped.WriteByte(c)
+		case c == '-', c == '.', c == '_', c == '~', c == '/' && keepSlash:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
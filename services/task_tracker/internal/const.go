@@ -3,13 +3,69 @@ package internal
 const (
 	dbDriver = "postgres"
 
-	requestParamUserID = "user_id"
-	requestParamTaskID = "task_id"
+	// outboxChannel is notified by the database whenever new outbox events are committed
+	outboxChannel = "outbox"
+	// streamChannel is notified with the ID of every committed outbox event, each replica
+	// listens to it to push the event to the streams connected to the replica
+	streamChannel = "outbox_stream"
+
+	requestParamUserID    = "user_id"
+	requestParamTaskID    = "task_id"
+	requestParamCommentID = "comment_id"
+	requestParamBlockerID = "blocker_id"
+
+	requestParamTemplateID   = "template_id"
+	requestParamAttachmentID = "attachment_id"
+	requestParamWorkerID     = "worker_id"
+	requestParamEventID      = "event_id"
+
+	queryParamAssigneeID = "assignee_id"
+	queryParamDueAfter   = "due_after"
+	queryParamDueBefore  = "due_before"
+	queryParamOverdue    = "overdue"
+	queryParamLabel      = "label"
+	queryParamPriority   = "priority"
+	queryParamQuery      = "q"
+	queryParamLimit      = "limit"
+	queryParamFormat     = "format"
+	queryParamDryRun     = "dry_run"
 )
 
 const (
 	HeaderAuth   = "Authorization"
 	HeaderBearer = "Bearer "
+
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotent-Replayed"
+)
+
+const bodyMaxSize = 1 << 10
+
+const (
+	jsonTransferFormat = "json"
+	csvTransferFormat  = "csv"
+
+	jsonContentType = "application/json"
+	csvContentType  = "text/csv"
+
+	// exportFlushRows is the number of rows sent to the client at once
+	exportFlushRows = 100
+)
+
+const (
+	attachmentFormField = "file"
+	// attachmentFormOverhead leaves room for the multipart boundaries and part headers
+	attachmentFormOverhead  = 1 << 14
+	attachmentNameMaxLength = 255
+)
+
+const (
+	idempotencyKeyMaxLength = 255
+	idempotencyMaxBodySize  = 1 << 20
 )
 
 const (
@@ -30,29 +86,123 @@ type TaskStatus string
 const (
 	createdStatus   TaskStatus = "created"
 	completedStatus TaskStatus = "completed"
+	cancelledStatus TaskStatus = "cancelled"
+	// unassignedStatus is an open task waiting for a worker with spare capacity
+	unassignedStatus TaskStatus = "unassigned"
 )
 
+const taskTitleMaxLength = 255
+
+type TaskBulkOperation string
+
 const (
-	RabbitProtocol    = "amqp"
-	RabbitDurable     = true
-	RabbitAutoDelete  = false
-	RabbitExclusive   = false
-	RabbitNoWait      = false
-	RabbitNoLocal     = false
-	RabbitExchange    = "task_tracker.out"
-	RabbitQueue       = "task_tracker.in"
-	RabbitMandatory   = false
-	RabbitImmediate   = false
-	RabbitContentType = "text/plain"
-	RabbitConsumer    = ""
-	RabbitAutoAck     = true
+	completeBulkOperation  TaskBulkOperation = "complete"
+	cancelBulkOperation    TaskBulkOperation = "cancel"
+	reassignBulkOperation  TaskBulkOperation = "reassign"
+	setLabelsBulkOperation TaskBulkOperation = "set_labels"
 )
 
-type EventType string
+type TaskBulkMode string
+
+const (
+	transactionalBulkMode TaskBulkMode = "transactional"
+	bestEffortBulkMode    TaskBulkMode = "best_effort"
+)
+
+type TaskBulkResultStatus string
+
+const (
+	appliedBulkResult TaskBulkResultStatus = "applied"
+	failedBulkResult  TaskBulkResultStatus = "failed"
+	skippedBulkResult TaskBulkResultStatus = "skipped"
+)
+
+const (
+	taskBulkMaxItems = 200
+	// taskBulkBodyMaxSize fits the maximum number of task ids along with the labels
+	taskBulkBodyMaxSize = 1 << 14
+)
+
+const (
+	// taskSearchConfig has to match the text search configuration of the search_vector triggers
+	taskSearchConfig = "english"
+
+	taskSearchDefaultLimit = 20
+	taskSearchMaxLimit     = 100
+)
 
 const (
-	userCreatedEventType   EventType = "user_created"
-	taskCreatedEventType   EventType = "task_created"
-	taskCompletedEventType EventType = "task_completed"
-	taskAssignedEventType  EventType = "task_assigned"
+	deadLetterDefaultLimit = 20
+	deadLetterMaxLimit     = 100
 )
+
+const autoCompleteReason = "all subtasks are completed"
+
+// schedulerLockName names the Postgres advisory lock, which keeps a single task tracker replica
+// materializing templates at a time. The lock key is hashed from the name, see advisoryLockKey
+const schedulerLockName = "task_tracker.scheduler"
+
+type TaskPriority string
+
+const (
+	lowPriority      TaskPriority = "low"
+	normalPriority   TaskPriority = "normal"
+	highPriority     TaskPriority = "high"
+	criticalPriority TaskPriority = "critical"
+)
+
+type TaskAction string
+
+const (
+	createdAction   TaskAction = "created"
+	assignedAction  TaskAction = "assigned"
+	completedAction TaskAction = "completed"
+	cancelledAction TaskAction = "cancelled"
+	reopenedAction  TaskAction = "reopened"
+	labelledAction  TaskAction = "labelled"
+
+	unassignedAction TaskAction = "unassigned"
+)
+
+const (
+	RabbitProtocol   = "amqp"
+	RabbitDurable    = true
+	RabbitAutoDelete = false
+	RabbitExclusive  = false
+	RabbitNoWait     = false
+	RabbitNoLocal    = false
+	RabbitExchange   = "task_tracker.out"
+	// RabbitExchangeKind and RabbitInternal have to match the exchange in the event bus definitions
+	RabbitExchangeKind = "fanout"
+	RabbitInternal     = false
+	RabbitQueue        = "task_tracker.in"
+	RabbitMandatory    = true
+	RabbitImmediate    = false
+	RabbitConsumer     = ""
+	RabbitAutoAck      = false
+	// RabbitDefaultExchange routes the messages straight to the queue named by the routing key
+	RabbitDefaultExchange = ""
+	RabbitRetrySuffix     = ".retry"
+	RabbitParkingSuffix   = ".dlq"
+	RabbitNotifyBuffer    = 16
+)
+
+type RabbitState string
+
+const (
+	RabbitConnected    RabbitState = "connected"
+	RabbitReconnecting RabbitState = "reconnecting"
+	RabbitClosed       RabbitState = "closed"
+)
+
+// Headers of the retried and parked messages
+const (
+	RabbitHeaderRetryCount = "x-retry-count"
+	RabbitHeaderLastError  = "x-last-error"
+	RabbitHeaderFailedAt   = "x-failed-at"
+)
+
+// eventProducer names the service in the envelopes of its events
+const eventProducer = "task_tracker"
+
+type EventType string
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2024-01-01 is a Monday
+	monday := time.Date(2024, time.January, 1, 10, 7, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "step",
+			spec: "*/15 * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 1, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "step from a match",
+			spec: "*/15 * * * *",
+			from: time.Date(2024, time.January, 1, 10, 15, 0, 0, time.UTC),
+			want: time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "step with a start",
+			spec: "5/20 * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 1, 10, 25, 0, 0, time.UTC),
+		},
+		{
+			name: "range",
+			spec: "0 9-17 * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range past its end",
+			spec: "0 9-17 * * *",
+			from: time.Date(2024, time.January, 1, 17, 30, 0, 0, time.UTC),
+			want: time.Date(2024, time.January, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "list",
+			spec: "0,30 * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "hourly",
+			spec: "@hourly",
+			from: monday,
+			want: time.Date(2024, time.January, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "daily",
+			spec: "@daily",
+			from: monday,
+			want: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekly",
+			spec: "@weekly",
+			from: monday,
+			want: time.Date(2024, time.January, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			from: monday,
+			want: time.Date(2024, time.January, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of week alone",
+			spec: "0 0 * * 5",
+			from: monday,
+			want: time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month alone",
+			spec: "0 0 15 * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or week, the week first",
+			spec: "0 0 15 * 5",
+			from: monday,
+			want: time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or week, the month first",
+			spec: "0 0 15 * 5",
+			from: time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 2 *",
+			from: monday,
+			want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never",
+			spec: "0 0 30 2 *",
+			from: monday,
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCron: %s", err.Error())
+			}
+
+			next := schedule.Next(tt.from)
+			if !next.Equal(tt.want) {
+				t.Errorf("Next: got %s, want %s", next, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{name: "minute out of range", spec: "60 * * * *"},
+		{name: "hour out of range", spec: "* 24 * * *"},
+		{name: "day of month out of range", spec: "* * 0 * *"},
+		{name: "month out of range", spec: "* * * 13 *"},
+		{name: "day of week out of range", spec: "* * * * 8"},
+		{name: "reversed range", spec: "5-1 * * * *"},
+		{name: "zero step", spec: "*/0 * * * *"},
+		{name: "not a number", spec: "a * * * *"},
+		{name: "too few fields", spec: "* * * *"},
+		{name: "too many fields", spec: "* * * * * *"},
+		{name: "unknown alias", spec: "@reboot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCron(tt.spec)
+			if !errors.Is(err, ErrInvalidCron) {
+				t.Errorf("ParseCron: got %v, want %v", err, ErrInvalidCron)
+			}
+		})
+	}
+}
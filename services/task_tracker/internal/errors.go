@@ -14,4 +14,44 @@ var (
 	ErrRequestBodyDeconding = errors.New("request body contains badly formed JSON")
 	ErrUnathorizedUser      = errors.New("unauthorized user")
 	ErrWrongSignMethod      = errors.New("incorrect sign method")
+	ErrInvalidQueryParam    = errors.New("invalid query parameter")
+	ErrTaskStatusConflict   = errors.New("task status doesn't allow the transition")
+	ErrTaskVersionConflict  = errors.New("task has been changed since the version")
+	ErrNoWorkers            = errors.New("there are no workers to assign the task to")
+	ErrWorkerAtCapacity     = errors.New("worker has no room for another open task")
+	ErrInvalidPriority      = errors.New("unknown task priority")
+	ErrTaskTitleTooLong     = errors.New("task title is too long")
+	ErrInvalidCron          = errors.New("invalid cron expression")
+	ErrTaskNotFound         = errors.New("task is not found")
+	ErrTaskForbidden        = errors.New("user is not allowed to change the task")
+	ErrInvalidBulkOperation = errors.New("unknown bulk operation")
+	ErrInvalidBulkRequest   = errors.New("bulk request is malformed")
+	ErrInvalidAssignee      = errors.New("assignee is not a worker")
+	ErrTaskBulkAborted      = errors.New("bulk operation is rolled back")
+	ErrImportMediaType      = errors.New("Content-Type header is neither application/json nor text/csv")
+	ErrInvalidImport        = errors.New("import file is malformed")
+	ErrImportTooLarge       = errors.New("import file exceeds the limits")
+	ErrUnknownUsername      = errors.New("unknown username")
+	ErrTaskBlocked          = errors.New("task has open blockers")
+	ErrTaskDependencyCycle  = errors.New("task dependency makes a cycle")
+	ErrRelatedTaskNotFound  = errors.New("related task is not found")
+	ErrInvalidIfMatch       = errors.New("If-Match header is not a task version")
+	ErrWeakIfMatch          = errors.New("If-Match header holds a weak entity tag")
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used for another request")
+	ErrIdempotencyKeyLocked = errors.New("request with the idempotency key is still in progress")
+	ErrAttachmentForm       = errors.New("request body is not a multipart form with a file")
+	ErrAttachmentTooLarge   = errors.New("attachment exceeds the size limit")
+	ErrAttachmentType       = errors.New("attachment type is not allowed")
+	ErrUnknownBlobBackend   = errors.New("unknown blob store backend")
+	ErrInvalidBlobKey       = errors.New("blob key points outside of the store")
+	ErrBlobNotFound         = errors.New("blob is not found")
+	ErrBlobStoreRequest     = errors.New("blob store request failed")
+	ErrDuplicateEvent       = errors.New("event has been processed already")
+	ErrDeadLetterNotFound   = errors.New("dead letter is not found")
+	ErrConsumerClosed       = errors.New("event bus consumer is closed")
+	ErrEventBusUnavailable  = errors.New("event bus connection is down")
+	ErrEventBusClosed       = errors.New("event bus client is closed")
+	ErrPublishNacked        = errors.New("event bus has rejected the message")
+	ErrPublishReturned      = errors.New("message isn't routed to any queue")
+	ErrConfirmTimeout       = errors.New("event bus hasn't confirmed the message in time")
 )
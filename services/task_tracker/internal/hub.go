@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+func NewHub(config *Config) *Hub {
+	return &Hub{
+		config:      config,
+		subscribers: make(map[uuid.UUID]map[*Subscriber]struct{}),
+	}
+}
+
+// Subscribe registers a new streaming connection of the user
+func (h *Hub) Subscribe(userID uuid.UUID) *Subscriber {
+	sub := &Subscriber{
+		userID: userID,
+		events: make(chan *StreamEvent, h.config.Stream.BufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscriber]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes the connection, it's safe to call it more than once
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(sub)
+}
+
+// Broadcast pushes the event to every connection of the given users. It never
+// blocks: a subscriber whose buffer is full is considered too slow and gets
+// disconnected, so the client has to reconnect and refetch the tasks
+func (h *Hub) Broadcast(eventType EventType, msg interface{}, userIDs ...uuid.UUID) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("json.Marshal: %s\n", err.Error())
+		return
+	}
+
+	event := &StreamEvent{
+		Type: eventType,
+		Data: data,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[uuid.UUID]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		seen[userID] = struct{}{}
+
+		for sub := range h.subscribers[userID] {
+			select {
+			case sub.events <- event:
+			default:
+				log.Printf("dropping slow stream subscriber of user %s\n", userID)
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// Close disconnects all the subscribers, e.g. to let the server shut down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+
+	h.closed = true
+}
+
+// Events returns the channel which is closed once the subscriber is disconnected
+func (sub *Subscriber) Events() <-chan *StreamEvent {
+	return sub.events
+}
+
+func (h *Hub) remove(sub *Subscriber) {
+	subs, ok := h.subscribers[sub.userID]
+	if !ok {
+		return
+	}
+
+	if _, ok = subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.events)
+
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+}
@@ -1,14 +1,21 @@
 package internal
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/gocraft/dbr/v2"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
 // LogRequest is for logging current handler URI
@@ -90,3 +97,184 @@ func MiddlewareUserCtx(config *Config) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// MiddlewareIdempotency replays the stored response of a mutating request
+// retried with the same Idempotency-Key header instead of handling it again
+func MiddlewareIdempotency(config *Config, storage *Storage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyValue := r.Header.Get(HeaderIdempotencyKey)
+			if keyValue == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(keyValue) > idempotencyKeyMaxLength {
+				code := http.StatusBadRequest
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyBodyLimit(config)))
+			if err != nil {
+				code := http.StatusRequestEntityTooLarge
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+			key := &IdempotencyKey{
+				UserID:      userID,
+				Key:         keyValue,
+				ExpiresAt:   time.Now().Add(config.Idempotency.TTL),
+				RequestHash: requestHash(r, body),
+			}
+
+			stored, err := storage.ReserveIdempotencyKey(key, config.Idempotency.Lease)
+			if err != nil {
+				log.Printf("storage.ReserveIdempotencyKey: %s\n", err.Error())
+				code := http.StatusInternalServerError
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			if stored != nil {
+				replayIdempotentResponse(w, key, stored)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			serveIdempotent(next, recorder, r, storage, key)
+
+			// Server errors are not cached, so the client is able to retry them
+			if recorder.statusCode() >= http.StatusInternalServerError {
+				releaseIdempotencyKey(storage, key)
+				return
+			}
+
+			key.StatusCode = dbr.NewNullInt64(recorder.statusCode())
+			key.ContentType = recorder.Header().Get("Content-Type")
+			key.Response = recorder.body.Bytes()
+
+			err = storage.SaveIdempotentResponse(key)
+			if err != nil {
+				log.Printf("storage.SaveIdempotentResponse: %s\n", err.Error())
+			}
+		})
+	}
+}
+
+// serveIdempotent handles the request, releasing the key if the handler panics,
+// otherwise the retries would be locked out until the lease runs out
+func serveIdempotent(
+	next http.Handler,
+	recorder *responseRecorder,
+	r *http.Request,
+	storage *Storage,
+	key *IdempotencyKey,
+) {
+	defer func() {
+		if p := recover(); p != nil {
+			releaseIdempotencyKey(storage, key)
+			panic(p)
+		}
+	}()
+
+	next.ServeHTTP(recorder, r)
+}
+
+func releaseIdempotencyKey(storage *Storage, key *IdempotencyKey) {
+	err := storage.DeleteIdempotencyKey(key.UserID, key.Key)
+	if err != nil {
+		log.Printf("storage.DeleteIdempotencyKey: %s\n", err.Error())
+	}
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, key, stored *IdempotencyKey) {
+	var err error
+
+	switch {
+	case stored.RequestHash != key.RequestHash:
+		err = ErrIdempotencyKeyReused
+	case !stored.StatusCode.Valid:
+		err = ErrIdempotencyKeyLocked
+	}
+
+	if err != nil {
+		log.Printf("idempotency key %q: %s\n", key.Key, err.Error())
+		code := http.StatusUnprocessableEntity
+		if errors.Is(err, ErrIdempotencyKeyLocked) {
+			code = http.StatusConflict
+		}
+
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(HeaderIdempotencyReplayed, "true")
+	w.WriteHeader(int(stored.StatusCode.Int64))
+	_, _ = w.Write(stored.Response)
+}
+
+// idempotencyBodyLimit lets the attachment uploads and the imports through,
+// they are way bigger than JSON bodies
+func idempotencyBodyLimit(config *Config) int64 {
+	limit := int64(idempotencyMaxBodySize)
+
+	if size := config.Attachment.MaxSize + attachmentFormOverhead; size > limit {
+		limit = size
+	}
+
+	if config.Transfer.ImportMaxSize > limit {
+		limit = config.Transfer.ImportMaxSize
+	}
+
+	return limit
+}
+
+// requestHash fingerprints the request, so a key can't be reused for another one
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	// The query is a part of the request, e.g. a dry run import differs from the real one
+	_, _ = fmt.Fprintf(hash, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	_, _ = hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder copies the response on its way to the client
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *responseRecorder) statusCode() int64 {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+
+	return int64(rr.status)
+}
@@ -0,0 +1,20 @@
+-- +goose Up
+
+CREATE TABLE task_history (
+    id          UUID        NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    task_id     UUID        NOT NULL,
+    actor_id    UUID        NOT NULL,
+    action      VARCHAR(20) NOT NULL,
+    status      VARCHAR(20) NOT NULL,
+    assignee_id UUID        NOT NULL,
+
+    CONSTRAINT fk_task_history_task_to_tasks FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_history_actor_to_users FOREIGN KEY (actor_id) REFERENCES users(id) ON UPDATE RESTRICT
+);
+
+CREATE INDEX idx_task_history_task_id ON task_history(task_id, created_at);
+
+-- +goose Down
+DROP TABLE task_history;
@@ -0,0 +1,32 @@
+-- +goose Up
+
+CREATE TABLE comments (
+    id         UUID        NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+
+    task_id    UUID        NOT NULL,
+    parent_id  UUID,
+    author_id  UUID        NOT NULL,
+    text       TEXT        NOT NULL DEFAULT '',
+
+    CONSTRAINT fk_comments_task_to_tasks FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CONSTRAINT fk_comments_parent_to_comments FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT fk_comments_author_to_users FOREIGN KEY (author_id) REFERENCES users(id) ON UPDATE RESTRICT
+);
+
+CREATE INDEX idx_comments_task_id ON comments(task_id, created_at);
+
+CREATE TABLE comment_mentions (
+    comment_id UUID NOT NULL,
+    user_id    UUID NOT NULL,
+
+    PRIMARY KEY (comment_id, user_id),
+    CONSTRAINT fk_comment_mentions_comment_to_comments FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT fk_comment_mentions_user_to_users FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE RESTRICT
+);
+
+-- +goose Down
+DROP TABLE comment_mentions;
+DROP TABLE comments;
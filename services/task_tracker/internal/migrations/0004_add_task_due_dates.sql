@@ -0,0 +1,14 @@
+-- +goose Up
+
+ALTER TABLE tasks
+    ADD COLUMN due_at     TIMESTAMPTZ,
+    ADD COLUMN overdue_at TIMESTAMPTZ;
+
+CREATE INDEX idx_tasks_due_at ON tasks(due_at) WHERE overdue_at IS NULL;
+
+-- +goose Down
+DROP INDEX idx_tasks_due_at;
+
+ALTER TABLE tasks
+    DROP COLUMN due_at,
+    DROP COLUMN overdue_at;
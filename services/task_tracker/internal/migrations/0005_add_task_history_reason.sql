@@ -0,0 +1,8 @@
+-- +goose Up
+
+ALTER TABLE task_history
+    ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE task_history
+    DROP COLUMN reason;
@@ -0,0 +1,20 @@
+-- +goose Up
+
+CREATE TABLE idempotency_keys (
+    user_id      UUID         NOT NULL,
+    key          VARCHAR(255) NOT NULL,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ  NOT NULL,
+
+    request_hash VARCHAR(64)  NOT NULL,
+    status_code  INTEGER,
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    response     BYTEA,
+
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+-- +goose Down
+DROP TABLE idempotency_keys;
@@ -0,0 +1,20 @@
+-- +goose Up
+
+ALTER TABLE tasks
+    ADD COLUMN labels   TEXT[]      NOT NULL DEFAULT '{}',
+    ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'normal';
+
+CREATE INDEX idx_tasks_labels ON tasks USING GIN (labels);
+
+ALTER TABLE users
+    ADD COLUMN skills TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN skills;
+
+DROP INDEX idx_tasks_labels;
+
+ALTER TABLE tasks
+    DROP COLUMN labels,
+    DROP COLUMN priority;
@@ -0,0 +1,31 @@
+-- +goose Up
+
+ALTER TABLE tasks
+    ADD COLUMN parent_id     UUID,
+    ADD COLUMN auto_complete BOOLEAN NOT NULL DEFAULT false,
+    ADD CONSTRAINT fk_tasks_parent_to_tasks FOREIGN KEY (parent_id) REFERENCES tasks(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_tasks_parent_id ON tasks(parent_id);
+
+CREATE TABLE task_dependencies (
+    task_id    UUID        NOT NULL,
+    blocker_id UUID        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (task_id, blocker_id),
+    CONSTRAINT fk_task_dependencies_task_to_tasks FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_dependencies_blocker_to_tasks FOREIGN KEY (blocker_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CONSTRAINT chk_task_dependencies_not_self CHECK (task_id <> blocker_id)
+);
+
+CREATE INDEX idx_task_dependencies_blocker_id ON task_dependencies(blocker_id);
+
+-- +goose Down
+DROP TABLE task_dependencies;
+
+DROP INDEX idx_tasks_parent_id;
+
+ALTER TABLE tasks
+    DROP CONSTRAINT fk_tasks_parent_to_tasks,
+    DROP COLUMN parent_id,
+    DROP COLUMN auto_complete;
@@ -0,0 +1,25 @@
+-- +goose Up
+
+CREATE TABLE task_templates (
+    id          UUID        NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    author_id   UUID        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    labels      TEXT[]      NOT NULL DEFAULT '{}',
+    priority    VARCHAR(10) NOT NULL DEFAULT 'normal',
+    schedule    TEXT        NOT NULL,
+    due_in      BIGINT      NOT NULL DEFAULT 0,
+    active      BOOLEAN     NOT NULL DEFAULT true,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ,
+
+    CONSTRAINT fk_task_templates_author_to_users FOREIGN KEY (author_id) REFERENCES users(id) ON UPDATE RESTRICT,
+    CONSTRAINT chk_task_templates_due_in CHECK (due_in >= 0)
+);
+
+CREATE INDEX idx_task_templates_next_run_at ON task_templates(next_run_at) WHERE active;
+
+-- +goose Down
+DROP TABLE task_templates;
@@ -0,0 +1,22 @@
+-- +goose Up
+
+CREATE TABLE task_attachments (
+    id           UUID         NOT NULL PRIMARY KEY,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+
+    task_id      UUID         NOT NULL,
+    uploader_id  UUID         NOT NULL,
+    filename     VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size         BIGINT       NOT NULL,
+    checksum     CHAR(64)     NOT NULL,
+    storage_key  TEXT         NOT NULL,
+
+    CONSTRAINT fk_task_attachments_task_to_tasks FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_attachments_uploader_to_users FOREIGN KEY (uploader_id) REFERENCES users(id) ON UPDATE RESTRICT
+);
+
+CREATE INDEX idx_task_attachments_task_id ON task_attachments(task_id);
+
+-- +goose Down
+DROP TABLE task_attachments;
@@ -0,0 +1,83 @@
+-- +goose Up
+
+ALTER TABLE tasks
+    ADD COLUMN title         VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN search_vector TSVECTOR     NOT NULL DEFAULT ''::tsvector;
+
+ALTER TABLE task_templates
+    ADD COLUMN title VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Title and labels weigh the most, then the description, then the comments
+-- +goose StatementBegin
+CREATE FUNCTION task_search_vector(task_id UUID, title TEXT, description TEXT, labels TEXT[]) RETURNS TSVECTOR AS $$
+SELECT
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', array_to_string(labels, ' ')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce((
+        SELECT string_agg(c.text, ' ')
+        FROM comments c
+        WHERE c.task_id = task_search_vector.task_id AND c.deleted_at IS NULL
+    ), '')), 'C');
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION tasks_search_vector_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := task_search_vector(NEW.id, NEW.title, NEW.description, NEW.labels);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_tasks_search_vector
+    BEFORE INSERT OR UPDATE OF title, description, labels ON tasks
+    FOR EACH ROW EXECUTE FUNCTION tasks_search_vector_trigger();
+
+-- +goose StatementBegin
+CREATE FUNCTION comments_search_vector_trigger() RETURNS TRIGGER AS $$
+DECLARE
+    changed_task_id UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_task_id := OLD.task_id;
+    ELSE
+        changed_task_id := NEW.task_id;
+    END IF;
+
+    UPDATE tasks
+    SET search_vector = task_search_vector(id, title, description, labels)
+    WHERE id = changed_task_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_comments_search_vector
+    AFTER INSERT OR UPDATE OF text, deleted_at OR DELETE ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_search_vector_trigger();
+
+UPDATE tasks
+SET search_vector = task_search_vector(id, title, description, labels);
+
+CREATE INDEX idx_tasks_search_vector ON tasks USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX idx_tasks_search_vector;
+
+DROP TRIGGER trg_comments_search_vector ON comments;
+DROP FUNCTION comments_search_vector_trigger();
+
+DROP TRIGGER trg_tasks_search_vector ON tasks;
+DROP FUNCTION tasks_search_vector_trigger();
+
+DROP FUNCTION task_search_vector(UUID, TEXT, TEXT, TEXT[]);
+
+ALTER TABLE task_templates
+    DROP COLUMN title;
+
+ALTER TABLE tasks
+    DROP COLUMN title,
+    DROP COLUMN search_vector;
@@ -0,0 +1,42 @@
+-- +goose Up
+
+-- Tasks are left without an assignee while every worker is at capacity
+ALTER TABLE tasks
+    ALTER COLUMN assignee_id DROP NOT NULL;
+
+-- The assignment re-attempts are made by the service itself, not by a user
+ALTER TABLE task_history
+    ALTER COLUMN actor_id DROP NOT NULL,
+    ALTER COLUMN assignee_id DROP NOT NULL;
+
+-- NULL means the global limit applies to the worker
+ALTER TABLE users
+    ADD COLUMN max_open_tasks INT CHECK (max_open_tasks >= 0);
+
+CREATE INDEX idx_tasks_assignee_id_open ON tasks(assignee_id) WHERE status = 'created';
+
+-- +goose Down
+DROP INDEX idx_tasks_assignee_id_open;
+
+ALTER TABLE users
+    DROP COLUMN max_open_tasks;
+
+-- There is no one to hand the unassigned tasks to, so they fall back to their authors
+UPDATE tasks
+SET assignee_id = author_id, status = 'created'
+WHERE assignee_id IS NULL;
+
+UPDATE task_history h
+SET assignee_id = t.assignee_id
+FROM tasks t
+WHERE t.id = h.task_id AND h.assignee_id IS NULL;
+
+DELETE FROM task_history
+WHERE actor_id IS NULL;
+
+ALTER TABLE task_history
+    ALTER COLUMN actor_id SET NOT NULL,
+    ALTER COLUMN assignee_id SET NOT NULL;
+
+ALTER TABLE tasks
+    ALTER COLUMN assignee_id SET NOT NULL;
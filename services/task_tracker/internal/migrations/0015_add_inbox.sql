@@ -0,0 +1,25 @@
+-- +goose Up
+
+-- Consumers deduplicate the redelivered events by their IDs
+ALTER TABLE outbox
+    ADD COLUMN event_id UUID NOT NULL DEFAULT uuid_generate_v4();
+
+CREATE UNIQUE INDEX idx_outbox_event_id ON outbox(event_id);
+
+-- Every consumed event is recorded in the transaction of its side effect,
+-- so a redelivered event is recognized and skipped
+CREATE TABLE inbox (
+    event_id     UUID        NOT NULL PRIMARY KEY,
+    event_type   VARCHAR(50) NOT NULL,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_inbox_processed_at ON inbox(processed_at);
+
+-- +goose Down
+DROP TABLE inbox;
+
+DROP INDEX idx_outbox_event_id;
+
+ALTER TABLE outbox
+    DROP COLUMN event_id;
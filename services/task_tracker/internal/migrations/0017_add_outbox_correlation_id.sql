@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- The events stored by the same transaction share the correlation ID,
+-- the ones stored before are correlated by their own IDs
+ALTER TABLE outbox
+    ADD COLUMN correlation_id UUID;
+
+UPDATE outbox
+SET correlation_id = event_id;
+
+ALTER TABLE outbox
+    ALTER COLUMN correlation_id SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE outbox
+    DROP COLUMN correlation_id;
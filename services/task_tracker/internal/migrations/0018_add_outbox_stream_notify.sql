@@ -0,0 +1,21 @@
+-- +goose Up
+
+-- Every replica pushes the committed events to the streams of its own users, so each event
+-- is announced by its ID on a channel of its own. Unlike the relay wake-ups, these aren't
+-- folded, the notifications of a transaction differ by the IDs
+-- +goose StatementBegin
+CREATE FUNCTION outbox_stream_notify_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('outbox_stream', NEW.id::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_outbox_stream_notify
+    AFTER INSERT ON outbox
+    FOR EACH ROW EXECUTE FUNCTION outbox_stream_notify_trigger();
+
+-- +goose Down
+DROP TRIGGER trg_outbox_stream_notify ON outbox;
+DROP FUNCTION outbox_stream_notify_trigger();
@@ -1,15 +1,21 @@
 package internal
 
 import (
+	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gocraft/dbr/v2"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/streadway/amqp"
+	"github.com/vashc/async_arch_course/pkg/events"
 )
 
 type Service struct {
@@ -17,10 +23,32 @@ type Service struct {
 	server  *http.Server
 	storage *Storage
 	client  *RabbitClient
+	hub     *Hub
+	blobs   BlobStore
 
 	*chi.Mux
 }
 
+// BlobStore keeps the attachment contents, the metadata lives in the database
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get returns ErrBlobNotFound if there is no blob with the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete is a no-op for the keys which don't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore keeps blobs as files under the root directory
+type LocalBlobStore struct {
+	root string
+}
+
+// S3BlobStore keeps blobs in an S3-compatible bucket
+type S3BlobStore struct {
+	config *BlobConfig
+	client *http.Client
+}
+
 type Storage struct {
 	sess *dbr.Session
 }
@@ -49,6 +77,14 @@ type RabbitConfig struct {
 	RabbitPort  string `envconfig:"RABBIT_PORT" required:"true" default:"5672"`
 	RabbitLogin string `envconfig:"RABBIT_LOGIN" required:"true" default:"user"`
 	RabbitPass  string `envconfig:"RABBIT_PASS" required:"true" default:"pass"`
+
+	// ReconnectBackoff is the delay of the first reconnection, it's doubled up to MaxReconnectBackoff
+	ReconnectBackoff    time.Duration `envconfig:"RABBIT_RECONNECT_BACKOFF" required:"true" default:"1s"`
+	MaxReconnectBackoff time.Duration `envconfig:"RABBIT_MAX_RECONNECT_BACKOFF" required:"true" default:"30s"`
+	// PublishTimeout bounds the wait for the connection while the event bus is down
+	PublishTimeout time.Duration `envconfig:"RABBIT_PUBLISH_TIMEOUT" required:"true" default:"5s"`
+	// ConfirmTimeout bounds the wait for the broker to confirm a published message
+	ConfirmTimeout time.Duration `envconfig:"RABBIT_CONFIRM_TIMEOUT" required:"true" default:"5s"`
 }
 
 func (rc *RabbitConfig) uri() string {
@@ -62,6 +98,88 @@ func (rc *RabbitConfig) uri() string {
 	)
 }
 
+type SchedulerConfig struct {
+	Interval time.Duration `envconfig:"SCHEDULER_INTERVAL" required:"true" default:"30s"`
+}
+
+type ScannerConfig struct {
+	OverdueInterval time.Duration `envconfig:"OVERDUE_SCAN_INTERVAL" required:"true" default:"1m"`
+}
+
+type StreamConfig struct {
+	BufferSize        int           `envconfig:"STREAM_BUFFER_SIZE" required:"true" default:"16"`
+	HeartbeatInterval time.Duration `envconfig:"STREAM_HEARTBEAT_INTERVAL" required:"true" default:"15s"`
+}
+
+type IdempotencyConfig struct {
+	TTL           time.Duration `envconfig:"IDEMPOTENCY_KEY_TTL" required:"true" default:"24h"`
+	PruneInterval time.Duration `envconfig:"IDEMPOTENCY_PRUNE_INTERVAL" required:"true" default:"1h"`
+	// Lease bounds how long a key stays reserved by a request which never finished, e.g. the process
+	// died in the middle of it. It has to outlast the longest request, the imports included
+	Lease time.Duration `envconfig:"IDEMPOTENCY_LEASE" required:"true" default:"5m"`
+}
+
+type ConsumerConfig struct {
+	Prefetch int `envconfig:"CONSUMER_PREFETCH" required:"true" default:"10"`
+	// MaxRetries is the number of retries before a failed message is parked
+	MaxRetries int `envconfig:"CONSUMER_MAX_RETRIES" required:"true" default:"3"`
+	// RetryBackoff is the delay of the first retry, it's doubled for every next one
+	RetryBackoff time.Duration `envconfig:"CONSUMER_RETRY_BACKOFF" required:"true" default:"5s"`
+}
+
+type InboxConfig struct {
+	// Retention has to outlast the redeliveries, an event redelivered after it is processed again
+	Retention     time.Duration `envconfig:"INBOX_RETENTION" required:"true" default:"168h"`
+	PruneInterval time.Duration `envconfig:"INBOX_PRUNE_INTERVAL" required:"true" default:"1h"`
+}
+
+type OutboxConfig struct {
+	// RelayInterval is how often the relay looks for pending events it hasn't been woken up for
+	RelayInterval time.Duration `envconfig:"OUTBOX_RELAY_INTERVAL" required:"true" default:"5s"`
+	BatchSize     uint64        `envconfig:"OUTBOX_BATCH_SIZE" required:"true" default:"100"`
+	// RetryBackoff is doubled with every failed attempt up to MaxBackoff
+	RetryBackoff time.Duration `envconfig:"OUTBOX_RETRY_BACKOFF" required:"true" default:"1s"`
+	MaxBackoff   time.Duration `envconfig:"OUTBOX_MAX_BACKOFF" required:"true" default:"5m"`
+	// ClaimLease is how long a batch is held by a relay, it has to outlast the publishing of the batch
+	ClaimLease    time.Duration `envconfig:"OUTBOX_CLAIM_LEASE" required:"true" default:"1m"`
+	Retention     time.Duration `envconfig:"OUTBOX_RETENTION" required:"true" default:"72h"`
+	PruneInterval time.Duration `envconfig:"OUTBOX_PRUNE_INTERVAL" required:"true" default:"1h"`
+}
+
+type AssignmentConfig struct {
+	// MatchSkills restricts assignees to workers whose skills match the task labels
+	MatchSkills bool `envconfig:"ASSIGN_MATCH_SKILLS" required:"true" default:"true"`
+	// MaxOpenTasks limits the open tasks of a worker unless it's set per user, zero means no limit
+	MaxOpenTasks int64 `envconfig:"ASSIGN_MAX_OPEN_TASKS" required:"true" default:"0"`
+	// RetryInterval is how often the unassigned tasks are offered to the workers again
+	RetryInterval time.Duration `envconfig:"ASSIGN_RETRY_INTERVAL" required:"true" default:"1m"`
+}
+
+type BlobConfig struct {
+	// Backend is either local or s3
+	Backend  string `envconfig:"BLOB_BACKEND" required:"true" default:"local"`
+	LocalDir string `envconfig:"BLOB_LOCAL_DIR" required:"true" default:"/var/lib/task_tracker/attachments"`
+
+	S3Endpoint  string        `envconfig:"BLOB_S3_ENDPOINT" default:"http://blob_store:9000"`
+	S3Region    string        `envconfig:"BLOB_S3_REGION" default:"us-east-1"`
+	S3Bucket    string        `envconfig:"BLOB_S3_BUCKET" default:"attachments"`
+	S3AccessKey string        `envconfig:"BLOB_S3_ACCESS_KEY"`
+	S3SecretKey string        `envconfig:"BLOB_S3_SECRET_KEY"`
+	S3Timeout   time.Duration `envconfig:"BLOB_S3_TIMEOUT" default:"30s"`
+}
+
+type AttachmentConfig struct {
+	MaxSize      int64         `envconfig:"ATTACHMENT_MAX_SIZE" required:"true" default:"10485760"`
+	AllowedTypes []string      `envconfig:"ATTACHMENT_ALLOWED_TYPES" required:"true" default:"image/png,image/jpeg,image/gif,image/webp,text/plain,application/pdf,application/zip,application/x-gzip"`
+	Timeout      time.Duration `envconfig:"ATTACHMENT_TIMEOUT" required:"true" default:"1m"`
+}
+
+type TransferConfig struct {
+	Timeout       time.Duration `envconfig:"TRANSFER_TIMEOUT" required:"true" default:"2m"`
+	ImportMaxSize int64         `envconfig:"IMPORT_MAX_SIZE" required:"true" default:"1048576"`
+	ImportMaxRows int           `envconfig:"IMPORT_MAX_ROWS" required:"true" default:"1000"`
+}
+
 type API struct {
 	Host string `envconfig:"TASK_TRACKER_HOST" required:"true" default:"0.0.0.0"`
 	Port string `envconfig:"TASK_TRACKER_PORT" required:"true" default:"8001"`
@@ -71,6 +189,18 @@ type Config struct {
 	DB       DB
 	EventBus RabbitConfig
 	API      API
+	Scanner  ScannerConfig
+	Stream   StreamConfig
+
+	Assignment  AssignmentConfig
+	Idempotency IdempotencyConfig
+	Scheduler   SchedulerConfig
+	Blob        BlobConfig
+	Attachment  AttachmentConfig
+	Transfer    TransferConfig
+	Outbox      OutboxConfig
+	Inbox       InboxConfig
+	Consumer    ConsumerConfig
 
 	JWTSecret string `envconfig:"JWT_SECRET" required:"true" default:"some_default_jwt_secret"`
 }
@@ -79,10 +209,52 @@ type TaskCreateResponse struct {
 	ID uuid.UUID `json:"id"`
 }
 
+type TaskTransitionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type TemplateRequest struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Labels      pq.StringArray `json:"labels"`
+	Priority    TaskPriority   `json:"priority"`
+	Schedule    string         `json:"schedule"`
+	// DueIn is the number of seconds the created tasks are due in, zero means no due date
+	DueIn int64 `json:"due_in"`
+}
+
+// WorkloadRequest sets the open tasks limit of a worker, null brings the global one back
+type WorkloadRequest struct {
+	MaxOpenTasks dbr.NullInt64 `json:"max_open_tasks"`
+}
+
+type TemplateCreateResponse struct {
+	ID        uuid.UUID `json:"id"`
+	NextRunAt time.Time `json:"next_run_at"`
+}
+
+type AttachmentCreateResponse struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type CommentCreateResponse struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type CommentRequest struct {
+	ParentID uuid.NullUUID `json:"parent_id"`
+	Text     string        `json:"text"`
+}
+
 type Response struct {
 	Status string `json:"status"`
 }
 
+type HealthResponse struct {
+	Status   string      `json:"status"`
+	EventBus RabbitState `json:"event_bus"`
+}
+
 type JWTClaims struct {
 	UserID uuid.UUID `json:"user_id"`
 
@@ -90,21 +262,242 @@ type JWTClaims struct {
 }
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Username  string    `json:"username"`
-	Role      Role      `json:"role"`
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Username  string         `json:"username"`
+	Role      Role           `json:"role"`
+	Skills    pq.StringArray `json:"skills"`
+	// MaxOpenTasks overrides the global limit of open tasks for the worker
+	MaxOpenTasks dbr.NullInt64 `json:"max_open_tasks"`
+}
+
+// Workload is the number of open tasks of a worker along with the limit on them
+type Workload struct {
+	UserID       uuid.UUID      `json:"user_id" db:"id"`
+	Username     string         `json:"username"`
+	Skills       pq.StringArray `json:"skills"`
+	MaxOpenTasks dbr.NullInt64  `json:"max_open_tasks"`
+	OpenTasks    int64          `json:"open_tasks"`
+	// Capacity is the limit in effect for the worker, null means no limit
+	Capacity dbr.NullInt64 `json:"capacity" db:"-"`
 }
 
 type Task struct {
 	ID          uuid.UUID  `json:"id"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
+	Title       string     `json:"title"`
 	Description string     `json:"description"`
 	Status      TaskStatus `json:"status"`
 	AuthorID    uuid.UUID  `json:"author_id"`
-	AssigneeID  uuid.UUID  `json:"assignee_id"`
+	// AssigneeID is the nil UUID while the task is unassigned
+	AssigneeID   uuid.UUID      `json:"assignee_id"`
+	DueAt        *time.Time     `json:"due_at,omitempty"`
+	OverdueAt    *time.Time     `json:"overdue_at,omitempty"`
+	Version      int64          `json:"version"`
+	Labels       pq.StringArray `json:"labels"`
+	Priority     TaskPriority   `json:"priority"`
+	ParentID     uuid.NullUUID  `json:"parent_id"`
+	AutoComplete bool           `json:"auto_complete"`
+	BlockedBy    []uuid.UUID    `json:"blocked_by" db:"-"`
+	SubtaskIDs   []uuid.UUID    `json:"subtask_ids" db:"-"`
+}
+
+// TaskTemplate is a recurring task, it's materialized into a real task
+// every time its cron schedule fires
+type TaskTemplate struct {
+	ID          uuid.UUID      `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	AuthorID    uuid.UUID      `json:"author_id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Labels      pq.StringArray `json:"labels"`
+	Priority    TaskPriority   `json:"priority"`
+	Schedule    string         `json:"schedule"`
+	DueIn       int64          `json:"due_in"`
+	Active      bool           `json:"active"`
+	NextRunAt   time.Time      `json:"next_run_at"`
+	LastRunAt   *time.Time     `json:"last_run_at,omitempty"`
+}
+
+type Attachment struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	TaskID      uuid.UUID `json:"task_id"`
+	UploaderID  uuid.UUID `json:"uploader_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+	StorageKey  string    `json:"-"`
+}
+
+// TaskImportRow is a task to import, the users are referred to by their usernames.
+// The empty author stands for the importing user, the empty assignee is picked
+// just like for the tasks created one by one
+type TaskImportRow struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Author      string       `json:"author"`
+	Assignee    string       `json:"assignee"`
+	Labels      []string     `json:"labels"`
+	Priority    TaskPriority `json:"priority"`
+	DueAt       *time.Time   `json:"due_at"`
+
+	// err is a parsing error of the row
+	err error
+}
+
+type TaskImportRowResult struct {
+	Row    int           `json:"row"`
+	TaskID uuid.NullUUID `json:"task_id"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type TaskImportReport struct {
+	DryRun   bool                   `json:"dry_run"`
+	Total    int                    `json:"total"`
+	Imported int                    `json:"imported"`
+	Failed   int                    `json:"failed"`
+	Rows     []*TaskImportRowResult `json:"rows"`
+}
+
+// TaskExportRecord is a task as it's exported, its fields match the import ones.
+// The assignee is null for the unassigned tasks
+type TaskExportRecord struct {
+	ID          uuid.UUID      `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Status      TaskStatus     `json:"status"`
+	Author      string         `json:"author"`
+	Assignee    dbr.NullString `json:"assignee"`
+	Labels      pq.StringArray `json:"labels"`
+	Priority    TaskPriority   `json:"priority"`
+	DueAt       *time.Time     `json:"due_at"`
+}
+
+// TaskExportWriter streams the exported tasks to the client
+type TaskExportWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	format  string
+	csv     *csv.Writer
+	rows    int
+}
+
+type TaskDependency struct {
+	TaskID    uuid.UUID
+	BlockerID uuid.UUID
+}
+
+type TaskDependencyRequest struct {
+	BlockerID uuid.UUID `json:"blocker_id"`
+}
+
+// TaskFilter narrows down task listings, zero fields are not applied
+type TaskFilter struct {
+	AssigneeID uuid.NullUUID
+	DueAfter   *time.Time
+	DueBefore  *time.Time
+	Overdue    *bool
+	Labels     []string
+	Priority   TaskPriority
+}
+
+type TaskHistoryRecord struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	TaskID     uuid.UUID  `json:"task_id"`
+	ActorID    uuid.UUID  `json:"actor_id"`
+	Action     TaskAction `json:"action"`
+	Status     TaskStatus `json:"status"`
+	AssigneeID uuid.UUID  `json:"assignee_id"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// TaskTransition is a status change of a task made by a user. A non-zero
+// version makes the change conditional on the task not being changed since
+type TaskTransition struct {
+	TaskID  uuid.UUID
+	ActorID uuid.UUID
+	Action  TaskAction
+	Reason  string
+	Version int64
+}
+
+// TaskBulk is a single operation applied to a number of tasks at once
+type TaskBulk struct {
+	Operation  TaskBulkOperation
+	Mode       TaskBulkMode
+	ActorID    uuid.UUID
+	Reason     string
+	AssigneeID uuid.UUID
+	Labels     pq.StringArray
+	Items      []*TaskBulkItem
+}
+
+// TaskBulkItem is a task of the bulk, the task is updated in place. Prev is the
+// task as it was before the change and Parents are the auto-completed parents
+type TaskBulkItem struct {
+	Task    *Task
+	Prev    *Task
+	Parents []*Task
+	Err     error
+}
+
+type TaskBulkRequest struct {
+	TaskIDs    []uuid.UUID       `json:"task_ids"`
+	Operation  TaskBulkOperation `json:"operation"`
+	Mode       TaskBulkMode      `json:"mode"`
+	AssigneeID uuid.UUID         `json:"assignee_id"`
+	Labels     []string          `json:"labels"`
+	Reason     string            `json:"reason"`
+}
+
+type TaskBulkResult struct {
+	TaskID uuid.UUID            `json:"task_id"`
+	Status TaskBulkResultStatus `json:"status"`
+	Code   int                  `json:"code,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+type TaskBulkResponse struct {
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+	Results []*TaskBulkResult `json:"results"`
+}
+
+type Comment struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	DeletedAt *time.Time    `json:"deleted_at,omitempty"`
+	TaskID    uuid.UUID     `json:"task_id"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	AuthorID  uuid.UUID     `json:"author_id"`
+	Text      string        `json:"text"`
+	Mentions  []uuid.UUID   `json:"mentions" db:"-"`
+}
+
+type CommentMention struct {
+	CommentID uuid.UUID
+	UserID    uuid.UUID
+}
+
+// IdempotencyKey keeps the response of a mutating request, so a retry with
+// the same key gets it back instead of repeating the request
+type IdempotencyKey struct {
+	UserID      uuid.UUID
+	Key         string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	RequestHash string
+	StatusCode  dbr.NullInt64
+	ContentType string
+	Response    []byte
 }
 
 type Worker struct {
@@ -112,9 +505,134 @@ type Worker struct {
 	storage      *Storage
 	client       *http.Client
 	rabbitClient *RabbitClient
+	dispatcher   *events.Dispatcher
+}
+
+type Scanner struct {
+	config  *Config
+	storage *Storage
+}
+
+// Relay publishes the outbox events to the event bus
+type Relay struct {
+	config  *Config
+	storage *Storage
+	client  *RabbitClient
+}
+
+// Streamer pushes the committed outbox events to the user streams of the replica it runs on
+type Streamer struct {
+	config  *Config
+	storage *Storage
+	hub     *Hub
+}
+
+// InboxEvent is a consumed event, it's recorded to recognize the redeliveries of it
+type InboxEvent struct {
+	EventID     uuid.UUID
+	EventType   EventType
+	ProcessedAt time.Time
 }
 
+// OutboxEvent is an event stored along with the change it tells about. The relay
+// publishes it afterwards, so an event bus outage doesn't lose the event
+type OutboxEvent struct {
+	ID            int64          `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	EventID       uuid.UUID      `json:"event_id"`
+	CorrelationID uuid.UUID      `json:"correlation_id"`
+	EventType     EventType      `json:"event_type"`
+	EventVersion  int            `json:"event_version"`
+	Payload       []byte         `json:"payload"`
+	Recipients    pq.StringArray `json:"recipients"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error"`
+	SentAt        *time.Time     `json:"sent_at"`
+}
+
+// Scheduler materializes task templates into tasks and retries the assignment of unassigned ones
+type Scheduler struct {
+	config  *Config
+	storage *Storage
+	service *Service
+}
+
+// Hub fans task events out to the users' streaming connections
+type Hub struct {
+	config *Config
+
+	mu          sync.RWMutex
+	subscribers map[uuid.UUID]map[*Subscriber]struct{}
+	closed      bool
+}
+
+// Subscriber is a single streaming connection of a user
+type Subscriber struct {
+	userID uuid.UUID
+	events chan *StreamEvent
+}
+
+type StreamEvent struct {
+	Type EventType
+	Data []byte
+}
+
+// CronSchedule is a parsed cron expression, every field is kept as a bitset
+type CronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domStar bool
+	dowStar bool
+}
+
+// DeadLetter is a parked message which has failed all of its retries
+type DeadLetter struct {
+	EventID   string    `json:"event_id"`
+	EventType EventType `json:"event_type"`
+	Retries   int       `json:"retries"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+	// Body is kept as text, the message may be parked for not being valid JSON
+	Body string `json:"body"`
+}
+
+type DeadLetterListResponse struct {
+	Total   int           `json:"total"`
+	Letters []*DeadLetter `json:"letters"`
+}
+
+// RabbitClient keeps a single connection to the event bus, which is replaced whenever it's lost
 type RabbitClient struct {
-	conn *amqp.Connection
-	ch   *amqp.Channel
+	config *RabbitConfig
+	// registry keeps the schemas the events are validated against
+	registry *events.Registry
+	// prefetch limits the unacked messages delivered to the consumer
+	prefetch int
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ch    *rabbitChannel
+	state RabbitState
+	// ready is closed once the client is connected, a new one is made on a disconnection
+	ready chan struct{}
+	// topology is declared on every connection
+	topology []func(ch *amqp.Channel) error
+	done     chan struct{}
+	// publishMu keeps a single message waiting for the confirm at a time
+	publishMu sync.Mutex
+}
+
+// rabbitChannel is the channel of the connection in the confirm mode
+type rabbitChannel struct {
+	*amqp.Channel
+
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	// published is the delivery tag of the last published message
+	published uint64
 }
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vashc/async_arch_course/pkg/events"
+)
+
+func NewRelay(config *Config, storage *Storage, client *RabbitClient) *Relay {
+	return &Relay{
+		config:  config,
+		storage: storage,
+		client:  client,
+	}
+}
+
+// Process publishes the outbox events as soon as the database notifies about them.
+// The ticker picks up the events retried after a backoff and the missed notifications
+func (r *Relay) Process(ctx context.Context) error {
+	listener := pq.NewListener(r.config.DB.uri(), time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	err := listener.Listen(outboxChannel)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(r.config.Outbox.RelayInterval)
+	defer ticker.Stop()
+
+	pruneTicker := time.NewTicker(r.config.Outbox.PruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-pruneTicker.C:
+			count, err := r.storage.PruneOutboxEvents(time.Now().Add(-r.config.Outbox.Retention))
+			if err != nil {
+				log.Printf("storage.PruneOutboxEvents error: %s\n", err.Error())
+				continue
+			}
+
+			log.Printf("Pruned %d sent outbox events\n", count)
+
+			continue
+		case <-listener.Notify:
+		case <-ticker.C:
+		}
+
+		err = r.relayEvents()
+		if err != nil {
+			log.Printf("task_tracker.relayEvents error: %s\n", err.Error())
+		}
+	}
+}
+
+// relayEvents publishes the pending events batch by batch until there are none left
+func (r *Relay) relayEvents() error {
+	for {
+		sent, err := r.storage.RelayOutboxEvents(
+			r.config.Outbox.BatchSize,
+			r.config.Outbox.ClaimLease,
+			r.backoff,
+			r.publish,
+		)
+		if err != nil {
+			return err
+		}
+
+		if uint64(sent) < r.config.Outbox.BatchSize {
+			return nil
+		}
+	}
+}
+
+// publish sends the event to the event bus, the streams are fed by the streamer of each replica
+func (r *Relay) publish(event *OutboxEvent) error {
+	return r.client.Publish("", &events.Envelope{
+		EventID:       event.EventID,
+		EventName:     string(event.EventType),
+		EventVersion:  event.EventVersion,
+		EventTime:     event.CreatedAt,
+		Producer:      eventProducer,
+		CorrelationID: event.CorrelationID.String(),
+		Data:          event.Payload,
+	})
+}
+
+// backoff doubles the retry delay with every failed attempt
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.config.Outbox.RetryBackoff
+	for i := 1; i < attempts && delay < r.config.Outbox.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.config.Outbox.MaxBackoff {
+		delay = r.config.Outbox.MaxBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func NewScanner(config *Config, storage *Storage) *Scanner {
+	return &Scanner{
+		config:  config,
+		storage: storage,
+	}
+}
+
+// Process periodically looks for tasks which missed their due date
+// and cleans up expired idempotency keys and processed inbox events
+func (s *Scanner) Process(ctx context.Context) error {
+	overdueTicker := time.NewTicker(s.config.Scanner.OverdueInterval)
+	defer overdueTicker.Stop()
+
+	pruneTicker := time.NewTicker(s.config.Idempotency.PruneInterval)
+	defer pruneTicker.Stop()
+
+	inboxTicker := time.NewTicker(s.config.Inbox.PruneInterval)
+	defer inboxTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-overdueTicker.C:
+			err := s.scanOverdue()
+			if err != nil {
+				log.Printf("task_tracker.scanOverdue error: %s\n", err.Error())
+			}
+		case <-pruneTicker.C:
+			count, err := s.storage.PruneIdempotencyKeys()
+			if err != nil {
+				log.Printf("storage.PruneIdempotencyKeys error: %s\n", err.Error())
+				continue
+			}
+
+			log.Printf("Pruned %d expired idempotency keys\n", count)
+		case <-inboxTicker.C:
+			count, err := s.storage.PruneInboxEvents(time.Now().Add(-s.config.Inbox.Retention))
+			if err != nil {
+				log.Printf("storage.PruneInboxEvents error: %s\n", err.Error())
+				continue
+			}
+
+			log.Printf("Pruned %d processed inbox events\n", count)
+		}
+	}
+}
+
+// scanOverdue marks the tasks which missed their due date, the events
+// about them are stored in the outbox along with the marks
+func (s *Scanner) scanOverdue() error {
+	tasks, err := s.storage.MarkOverdueTasks()
+	if err != nil {
+		return err
+	}
+
+	if len(tasks) > 0 {
+		log.Printf("Marked %d tasks overdue\n", len(tasks))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gocraft/dbr/v2"
+	"github.com/google/uuid"
+)
+
+func NewScheduler(config *Config, storage *Storage, service *Service) *Scheduler {
+	return &Scheduler{
+		config:  config,
+		storage: storage,
+		service: service,
+	}
+}
+
+// Process periodically turns the due task templates into tasks and offers
+// the unassigned tasks to the workers once again
+func (s *Scheduler) Process(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.Scheduler.Interval)
+	defer ticker.Stop()
+
+	assignTicker := time.NewTicker(s.config.Assignment.RetryInterval)
+	defer assignTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := s.runDueTemplates()
+			if err != nil {
+				log.Printf("task_tracker.runDueTemplates error: %s\n", err.Error())
+			}
+		case <-assignTicker.C:
+			err := s.assignUnassignedTasks()
+			if err != nil {
+				log.Printf("task_tracker.assignUnassignedTasks error: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// assignUnassignedTasks hands the waiting tasks to the workers who have got room for them.
+// The assignments are conditional on the task versions, so the replicas don't fight over tasks
+func (s *Scheduler) assignUnassignedTasks() error {
+	tasks, err := s.storage.GetTasksByStatus(unassignedStatus)
+	if err != nil {
+		return err
+	}
+
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	// No one makes the re-attempts but the service itself, so there is no actor
+	err = s.service.assignTasks(tasks, uuid.Nil)
+	if errors.Is(err, ErrNoWorkers) {
+		return nil
+	}
+
+	return err
+}
+
+// runDueTemplates creates a task per due template. Templates are claimed before the tasks are
+// created, so a failed run is handed back to be retried on the next tick. A template which can't
+// ever make a valid task is deactivated instead, otherwise it would be retried forever
+func (s *Scheduler) runDueTemplates() error {
+	// Postgres keeps microseconds, the claim time has to match the stored one to release the run
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	templates, err := s.storage.ClaimDueTemplates(now)
+	if err != nil {
+		return err
+	}
+
+	for _, template := range templates {
+		task := &Task{
+			Title:       template.Title,
+			Description: template.Description,
+			AuthorID:    template.AuthorID,
+			Labels:      template.Labels,
+			Priority:    template.Priority,
+		}
+
+		if template.DueIn > 0 {
+			dueAt := now.Add(time.Duration(template.DueIn) * time.Second)
+			task.DueAt = &dueAt
+		}
+
+		err = s.service.createTask(task)
+		if err != nil {
+			log.Printf("createTask for template %s: %s\n", template.ID, err.Error())
+			s.releaseTemplateRun(template, now, err)
+			continue
+		}
+
+		log.Printf("Created task %s from template %s\n", task.ID, template.ID)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) releaseTemplateRun(template *TaskTemplate, claimedAt time.Time, cause error) {
+	var err error
+
+	if errors.Is(cause, ErrInvalidPriority) || errors.Is(cause, ErrTaskTitleTooLong) {
+		log.Printf("Deactivating task template %s, it makes invalid tasks\n", template.ID)
+		err = s.storage.DeactivateTemplate(template.ID)
+	} else {
+		err = s.storage.ReleaseTemplateRun(template, claimedAt)
+	}
+
+	if err != nil && !errors.Is(err, dbr.ErrNotFound) {
+		log.Printf("task_tracker.releaseTemplateRun error: %s\n", err.Error())
+	}
+}
@@ -6,18 +6,26 @@ import (
 	"math/rand"
 	"time"
 
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gocraft/dbr/v2"
 	"github.com/google/uuid"
+	"github.com/streadway/amqp"
 )
 
-func NewService(config *Config, storage *Storage, client *RabbitClient) *Service {
+func NewService(config *Config, storage *Storage, client *RabbitClient, hub *Hub, blobs BlobStore) *Service {
 	server := &http.Server{
 		Addr:              fmt.Sprintf("%s:%s", config.API.Host, config.API.Port),
 		ReadHeaderTimeout: time.Second * 5,
@@ -28,6 +36,8 @@ func NewService(config *Config, storage *Storage, client *RabbitClient) *Service
 		server:  server,
 		storage: storage,
 		client:  client,
+		hub:     hub,
+		blobs:   blobs,
 		Mux:     chi.NewRouter(),
 	}
 
@@ -38,23 +48,115 @@ func NewService(config *Config, storage *Storage, client *RabbitClient) *Service
 
 func (s *Service) InstantiateRoutes() {
 	s.Use(
-		middleware.Timeout(5*time.Second),
 		LogRequest,
 		MiddlewareUserAuth,
 		MiddlewareUserCtx(s.config),
+		MiddlewareIdempotency(s.config, s.storage),
 	)
 
-	s.Route("/task", func(router chi.Router) {
+	// Streaming connections are long-lived, so they are kept out of the request timeout
+	s.Get("/task/stream", s.streamTasksHandler())
+
+	timeout := middleware.Timeout(5 * time.Second)
+
+	s.With(timeout).Route("/task", func(router chi.Router) {
 		router.Post("/create", s.createTaskHandler())
 		router.Post(
 			fmt.Sprintf("/{%s}/complete", requestParamTaskID),
 			s.completeTaskHandler(),
 		)
+		router.Post(
+			fmt.Sprintf("/{%s}/cancel", requestParamTaskID),
+			s.cancelTaskHandler(),
+		)
+		router.Post(
+			fmt.Sprintf("/{%s}/reopen", requestParamTaskID),
+			s.reopenTaskHandler(),
+		)
 		router.Get("/get", s.getTasksHandler())
+		router.Get("/search", s.searchTasksHandler())
+		router.Get(
+			fmt.Sprintf("/{%s}", requestParamTaskID),
+			s.getTaskHandler(),
+		)
 		router.Post("/assign", s.assignTasksHandler())
+		router.Post("/bulk", s.bulkTasksHandler())
+		router.Get("/workload", s.getWorkloadHandler())
+		router.Put(
+			fmt.Sprintf("/workload/{%s}", requestParamWorkerID),
+			s.setWorkerCapacityHandler(),
+		)
+		router.Get(
+			fmt.Sprintf("/{%s}/history", requestParamTaskID),
+			s.getTaskHistoryHandler(),
+		)
+
+		router.Route(fmt.Sprintf("/{%s}/dependency", requestParamTaskID), func(router chi.Router) {
+			router.Post("/create", s.addDependencyHandler())
+			router.Delete(
+				fmt.Sprintf("/{%s}", requestParamBlockerID),
+				s.removeDependencyHandler(),
+			)
+		})
+
+		router.Route(fmt.Sprintf("/{%s}/comment", requestParamTaskID), func(router chi.Router) {
+			router.Post("/create", s.createCommentHandler())
+			router.Get("/get", s.getCommentsHandler())
+			router.Put(
+				fmt.Sprintf("/{%s}", requestParamCommentID),
+				s.updateCommentHandler(),
+			)
+			router.Delete(
+				fmt.Sprintf("/{%s}", requestParamCommentID),
+				s.deleteCommentHandler(),
+			)
+		})
+	})
+
+	// Uploads and downloads may take a while, so they get a timeout of their own
+	attachmentTimeout := middleware.Timeout(s.config.Attachment.Timeout)
+
+	s.With(attachmentTimeout).Route(fmt.Sprintf("/task/{%s}/attachment", requestParamTaskID), func(router chi.Router) {
+		router.Post("/create", s.createAttachmentHandler())
+		router.Get("/get", s.getAttachmentsHandler())
+		router.Get(
+			fmt.Sprintf("/{%s}", requestParamAttachmentID),
+			s.downloadAttachmentHandler(),
+		)
+		router.Delete(
+			fmt.Sprintf("/{%s}", requestParamAttachmentID),
+			s.deleteAttachmentHandler(),
+		)
 	})
 
-	s.Get("/health", s.healthHandler())
+	// Imports and exports move lots of tasks, so they get a timeout of their own
+	transferTimeout := middleware.Timeout(s.config.Transfer.Timeout)
+
+	s.With(transferTimeout).Post("/task/import", s.importTasksHandler())
+	s.With(transferTimeout).Get("/task/export", s.exportTasksHandler())
+
+	s.With(timeout).Route("/template", func(router chi.Router) {
+		router.Post("/create", s.createTemplateHandler())
+		router.Get("/get", s.getTemplatesHandler())
+		router.Delete(
+			fmt.Sprintf("/{%s}", requestParamTemplateID),
+			s.deleteTemplateHandler(),
+		)
+	})
+
+	s.With(timeout).Route("/admin/dlq", func(router chi.Router) {
+		router.Get("/get", s.getDeadLettersHandler())
+		router.Post(
+			fmt.Sprintf("/{%s}/replay", requestParamEventID),
+			s.replayDeadLetterHandler(),
+		)
+		router.Delete(
+			fmt.Sprintf("/{%s}", requestParamEventID),
+			s.dropDeadLetterHandler(),
+		)
+	})
+
+	s.With(timeout).Get("/health", s.healthHandler())
 }
 
 func (s *Service) Start() error {
@@ -67,6 +169,9 @@ func (s *Service) Stop() error {
 		return err
 	}
 
+	// Let the streaming connections go, otherwise the shutdown waits for them forever
+	s.hub.Close()
+
 	err = s.server.Shutdown(context.Background())
 	if err != nil {
 		return err
@@ -86,45 +191,36 @@ func (s *Service) createTaskHandler() func(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		task.Status = createdStatus
 		task.AuthorID, _ = r.Context().Value(requestParamUserID).(uuid.UUID)
 
-		// Get a worker for the task randomly
-		users, err := s.storage.GetUsersByRole(workerRole)
+		user, err := s.storage.GetUserByID(task.AuthorID)
 		if err != nil {
-			log.Printf("storage.GetUsersByRole: %s\n", err.Error())
-			code := http.StatusInternalServerError
+			log.Printf("storage.GetUserByID: %s\n", err.Error())
+			code := http.StatusBadRequest
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		if len(users) == 0 {
+		err = s.checkRelatedTasks(user, task.ParentID, task.BlockedBy)
+		if err == nil {
+			err = s.createTask(task)
+		}
+
+		switch {
+		case errors.Is(err, ErrInvalidPriority), errors.Is(err, ErrTaskTitleTooLong):
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		case errors.Is(err, ErrRelatedTaskNotFound):
 			code := http.StatusBadRequest
 			http.Error(w, http.StatusText(code), code)
 			return
-		}
-
-		rand.Seed(time.Now().Unix())
-		//nolint:gosec // It's ok for now
-		task.AssigneeID = users[rand.Intn(len(users))].ID
-
-		if err = s.storage.CreateTask(task); err != nil {
-			log.Printf("storage.CreateTask: %s\n", err.Error())
-			code := http.StatusInternalServerError
+		case errors.Is(err, ErrTaskForbidden):
+			code := http.StatusForbidden
 			http.Error(w, http.StatusText(code), code)
 			return
-		}
-
-		// Create exchange message in a queue
-		taskCreated := TaskCreatedOut{
-			Description: task.Description,
-			Status:      task.Status,
-			AssigneeID:  task.AssigneeID,
-		}
-
-		err = s.client.Publish("", taskCreatedEventType, taskCreated)
-		if err != nil {
-			log.Printf("client.Publish: %s\n", err.Error())
+		case err != nil:
+			log.Printf("createTask: %s\n", err.Error())
 			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
@@ -164,22 +260,60 @@ func (s *Service) completeTaskHandler() func(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		err = s.storage.UpdateTaskStatus(taskID, completedStatus)
-		if err != nil {
+		version, err := ParseIfMatch(r)
+		switch {
+		case errors.Is(err, ErrWeakIfMatch):
+			code := http.StatusPreconditionFailed
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+		task, _, err = s.storage.UpdateTaskStatus(&TaskTransition{
+			TaskID:  taskID,
+			ActorID: userID,
+			Action:  completedAction,
+			Version: version,
+		})
+		switch {
+		case errors.Is(err, ErrTaskVersionConflict):
+			code := http.StatusPreconditionFailed
+			http.Error(w, http.StatusText(code), code)
+			return
+		case errors.Is(err, ErrTaskStatusConflict), errors.Is(err, ErrTaskBlocked):
+			code := http.StatusConflict
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
 			log.Printf("storage.UpdateTaskStatus: %s\n", err.Error())
 			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		// Create exchange message in a queue
-		taskCompleted := TaskCompletedOut{
-			AssigneeID: task.AssigneeID,
-		}
+		w.Header().Set(HeaderETag, FormatETag(task.Version+1))
 
-		err = s.client.Publish("", taskCompletedEventType, taskCompleted)
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
 		if err != nil {
-			log.Printf("client.Publish: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) cancelTaskHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok := s.transitTask(w, r, cancelledAction)
+		if !ok {
+			return
 		}
 
 		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
@@ -193,25 +327,58 @@ func (s *Service) completeTaskHandler() func(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func (s *Service) getTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+func (s *Service) reopenTaskHandler() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+		ok := s.transitTask(w, r, reopenedAction)
+		if !ok {
+			return
+		}
 
-		user, err := s.storage.GetUserByID(userID)
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
 		if err != nil {
-			log.Printf("storage.GetUserByID: %s\n", err.Error())
-			code := http.StatusBadRequest
+			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		if user.Role != workerRole {
-			code := http.StatusForbidden
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) getTaskHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		etag := FormatETag(task.Version)
+		w.Header().Set(HeaderETag, etag)
+
+		if r.Header.Get(HeaderIfNoneMatch) == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		resp, err := json.Marshal(task)
+		if err != nil {
+			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		tasks, err := s.storage.GetTasksByAssigneeID(userID)
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) getTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, ok := s.visibleTaskFilter(w, r)
+		if !ok {
+			return
+		}
+
+		tasks, err := s.storage.GetTasks(filter)
 		switch {
 		case errors.Is(err, dbr.ErrNotFound):
 			code := http.StatusNotFound
@@ -234,6 +401,39 @@ func (s *Service) getTasksHandler() func(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (s *Service) searchTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		text, limit, err := ParseTaskSearch(r)
+		if err != nil {
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		filter, ok := s.visibleTaskFilter(w, r)
+		if !ok {
+			return
+		}
+
+		tasks, err := s.storage.SearchTasks(text, filter, limit)
+		if err != nil {
+			log.Printf("storage.SearchTasks: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(tasks)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
 func (s *Service) assignTasksHandler() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
@@ -252,55 +452,103 @@ func (s *Service) assignTasksHandler() func(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		tasks, err := s.storage.GetTasksByStatus(createdStatus)
+		tasks, err := s.storage.GetTasksByStatus(openStatuses()...)
+		if err != nil {
+			log.Printf("storage.GetTasksByStatus: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.assignTasks(tasks, userID)
 		switch {
-		case errors.Is(err, dbr.ErrNotFound):
-			code := http.StatusNotFound
+		case errors.Is(err, ErrNoWorkers):
+			code := http.StatusBadRequest
 			http.Error(w, http.StatusText(code), code)
 			return
 		case err != nil:
-			log.Printf("storage.GetTasksByStatus: %s\n", err.Error())
-			code := http.StatusBadRequest
+			log.Printf("assignTasks: %s\n", err.Error())
+			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		users, err := s.storage.GetUsersByRole(workerRole)
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
 		if err != nil {
-			log.Printf("storage.GetUsersByRole: %s\n", err.Error())
 			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		if len(users) == 0 {
-			code := http.StatusBadRequest
+		_, _ = w.Write(resp)
+	}
+}
+
+// getWorkloadHandler shows the open tasks of every worker against their limits
+func (s *Service) getWorkloadHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		workload, err := s.storage.GetWorkload()
+		if err != nil {
+			log.Printf("storage.GetWorkload: %s\n", err.Error())
+			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		rand.Seed(time.Now().Unix())
-		for _, task := range tasks {
-			//nolint:gosec // It's ok for now
-			task.AssigneeID = users[rand.Intn(len(users))].ID
+		applyCapacity(workload, s.config.Assignment.MaxOpenTasks)
 
-			err = s.storage.UpdateTaskAssignee(task.ID, task.AssigneeID)
-			if err != nil {
-				log.Printf("storage.UpdateTaskAssignee: %s\n", err.Error())
-				code := http.StatusInternalServerError
-				http.Error(w, http.StatusText(code), code)
-				return
-			}
+		resp, err := json.Marshal(workload)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
 
-			// Create exchange message in a queue
-			taskAssigned := TaskAssignedOut{
-				AssigneeID: task.AssigneeID,
-			}
+		_, _ = w.Write(resp)
+	}
+}
 
-			err = s.client.Publish("", taskAssignedEventType, taskAssigned)
-			if err != nil {
-				log.Printf("client.Publish: %s\n", err.Error())
-			}
+// setWorkerCapacityHandler sets a limit of open tasks for a single worker. The limit
+// isn't enforced on the tasks the worker already has, it only stops new ones coming
+func (s *Service) setWorkerCapacityHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		workerID, err := uuid.Parse(chi.URLParam(r, requestParamWorkerID))
+		if err != nil {
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		req := new(WorkloadRequest)
+
+		err = BodyParser(w, r, req)
+		if err != nil || (req.MaxOpenTasks.Valid && req.MaxOpenTasks.Int64 < 0) {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.storage.SetWorkerCapacity(workerID, req.MaxOpenTasks)
+		switch {
+		case errors.Is(err, dbr.ErrNotFound):
+			code := http.StatusNotFound
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("storage.SetWorkerCapacity: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
 		}
 
 		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
@@ -314,15 +562,1600 @@ func (s *Service) assignTasksHandler() func(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-func (s *Service) healthHandler() func(w http.ResponseWriter, r *http.Request) {
+func (s *Service) bulkTasksHandler() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+		user, err := s.storage.GetUserByID(userID)
+		if err != nil {
+			log.Printf("storage.GetUserByID: %s\n", err.Error())
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		req := new(TaskBulkRequest)
+
+		err = BodyParserWithLimit(w, r, req, taskBulkBodyMaxSize)
+		if err != nil {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		bulk, err := s.newTaskBulk(user, req)
+		switch {
+		case errors.Is(err, ErrInvalidBulkRequest), errors.Is(err, ErrInvalidBulkOperation),
+			errors.Is(err, ErrInvalidAssignee):
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("newTaskBulk: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		ids := uniqueIDs(req.TaskIDs)
+
+		tasks, err := s.storage.GetTasksByIDs(ids)
 		if err != nil {
+			log.Printf("storage.GetTasksByIDs: %s\n", err.Error())
 			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		_, _ = w.Write(resp)
-	}
+		tasksByID := make(map[uuid.UUID]*Task, len(tasks))
+		for _, task := range tasks {
+			tasksByID[task.ID] = task
+		}
+
+		resp := &TaskBulkResponse{Results: make([]*TaskBulkResult, 0, len(ids))}
+		pending := make(map[uuid.UUID]*TaskBulkResult, len(ids))
+
+		// Permissions are checked up front, the state machine is checked by the storage
+		for _, id := range ids {
+			result := &TaskBulkResult{TaskID: id}
+			resp.Results = append(resp.Results, result)
+
+			err = checkTaskBulkItem(user, bulk, tasksByID[id])
+			if err != nil {
+				result.fail(err)
+				resp.Failed++
+				continue
+			}
+
+			pending[id] = result
+			bulk.Items = append(bulk.Items, &TaskBulkItem{Task: tasksByID[id]})
+		}
+
+		aborted := bulk.Mode == transactionalBulkMode && resp.Failed > 0
+
+		if !aborted {
+			err = s.storage.UpdateTasksInBulk(bulk)
+			aborted = errors.Is(err, ErrTaskBulkAborted)
+
+			if err != nil && !aborted {
+				log.Printf("storage.UpdateTasksInBulk: %s\n", err.Error())
+				code := http.StatusInternalServerError
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+		}
+
+		for _, item := range bulk.Items {
+			result := pending[item.Task.ID]
+
+			switch {
+			case item.Err != nil:
+				result.fail(item.Err)
+				resp.Failed++
+			case aborted:
+				result.Status = skippedBulkResult
+			default:
+				result.Status = appliedBulkResult
+				resp.Applied++
+			}
+		}
+
+		body, err := json.Marshal(resp)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if aborted {
+			w.WriteHeader(http.StatusConflict)
+		}
+
+		_, _ = w.Write(body)
+	}
+}
+
+func (s *Service) importTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var dryRun bool
+
+		if value := r.URL.Query().Get(queryParamDryRun); value != "" {
+			var err error
+
+			dryRun, err = strconv.ParseBool(value)
+			if err != nil {
+				code := http.StatusBadRequest
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+		}
+
+		rows, err := ParseTaskImport(w, r, &s.config.Transfer)
+		switch {
+		case errors.Is(err, ErrImportMediaType):
+			code := http.StatusUnsupportedMediaType
+			http.Error(w, http.StatusText(code), code)
+			return
+		case errors.Is(err, ErrImportTooLarge):
+			code := http.StatusRequestEntityTooLarge
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("ParseTaskImport: %s\n", err.Error())
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		tasks, report, err := s.prepareImport(user, rows)
+		if err != nil {
+			log.Printf("prepareImport: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		report.DryRun = dryRun
+
+		// Nothing is imported unless every row is fine, so the fixed file may be simply loaded again
+		if report.Failed == 0 && !dryRun {
+			err = s.storage.CreateTasks(tasks)
+			if err != nil {
+				log.Printf("storage.CreateTasks: %s\n", err.Error())
+				code := http.StatusInternalServerError
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			for i, task := range tasks {
+				report.Rows[i].TaskID = uuid.NullUUID{UUID: task.ID, Valid: true}
+				report.Imported++
+			}
+		}
+
+		resp, err := json.Marshal(report)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if report.Failed > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) exportTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get(queryParamFormat)
+
+		contentType := jsonContentType
+		switch format {
+		case "", jsonTransferFormat:
+			format = jsonTransferFormat
+		case csvTransferFormat:
+			contentType = csvContentType
+		default:
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		filter, ok := s.visibleTaskFilter(w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set(
+			"Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": "tasks." + format}),
+		)
+
+		writer := NewTaskExportWriter(w, format)
+
+		// The response is on its way already, so the errors are only logged
+		err := s.storage.ExportTasks(r.Context(), filter, writer.Write)
+		if err != nil {
+			log.Printf("storage.ExportTasks: %s\n", err.Error())
+			return
+		}
+
+		err = writer.Close()
+		if err != nil {
+			log.Printf("TaskExportWriter.Close: %s\n", err.Error())
+		}
+	}
+}
+
+func (s *Service) getTaskHistoryHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		records, err := s.storage.GetTaskHistory(task.ID)
+		if err != nil {
+			log.Printf("storage.GetTaskHistory: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(records)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) addDependencyHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		if !canEditTask(user, task) {
+			code := http.StatusForbidden
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		req := new(TaskDependencyRequest)
+
+		err := BodyParser(w, r, req)
+		if err != nil {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.checkRelatedTasks(user, uuid.NullUUID{}, []uuid.UUID{req.BlockerID})
+		if err == nil {
+			err = s.storage.AddTaskDependency(task, req.BlockerID)
+		}
+
+		switch {
+		case errors.Is(err, ErrRelatedTaskNotFound):
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		case errors.Is(err, ErrTaskDependencyCycle):
+			code := http.StatusConflict
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("storage.AddTaskDependency: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) removeDependencyHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		if !canEditTask(user, task) {
+			code := http.StatusForbidden
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		blockerID, err := uuid.Parse(chi.URLParam(r, requestParamBlockerID))
+		if err != nil {
+			log.Printf("uuid.Parse: %s\n", err.Error())
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.storage.RemoveTaskDependency(task, blockerID)
+		switch {
+		case errors.Is(err, dbr.ErrNotFound):
+			code := http.StatusNotFound
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("storage.RemoveTaskDependency: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) createCommentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		req := new(CommentRequest)
+
+		err := BodyParser(w, r, req)
+		if err != nil || strings.TrimSpace(req.Text) == "" {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if req.ParentID.Valid {
+			var parent *Comment
+			parent, err = s.storage.GetCommentByID(req.ParentID.UUID)
+			switch {
+			case errors.Is(err, dbr.ErrNotFound):
+				code := http.StatusBadRequest
+				http.Error(w, http.StatusText(code), code)
+				return
+			case err != nil:
+				log.Printf("storage.GetCommentByID: %s\n", err.Error())
+				code := http.StatusInternalServerError
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			// Replies can't jump between task threads
+			if parent.TaskID != task.ID {
+				code := http.StatusBadRequest
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+		}
+
+		mentions, err := s.resolveMentions(req.Text)
+		if err != nil {
+			log.Printf("storage.GetUsersByUsernames: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		comment := &Comment{
+			TaskID:   task.ID,
+			ParentID: req.ParentID,
+			AuthorID: user.ID,
+			Text:     req.Text,
+			Mentions: mentions,
+		}
+
+		if err = s.storage.CreateComment(task, comment); err != nil {
+			log.Printf("storage.CreateComment: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(CommentCreateResponse{ID: comment.ID})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) getCommentsHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		comments, err := s.storage.GetCommentsByTaskID(task.ID)
+		if err != nil {
+			log.Printf("storage.GetCommentsByTaskID: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(comments)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) updateCommentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		comment, ok := s.taskComment(w, r, task)
+		if !ok {
+			return
+		}
+
+		if comment.AuthorID != user.ID {
+			code := http.StatusForbidden
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		req := new(CommentRequest)
+
+		err := BodyParser(w, r, req)
+		if err != nil || strings.TrimSpace(req.Text) == "" {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		comment.Text = req.Text
+		comment.Mentions, err = s.resolveMentions(req.Text)
+		if err != nil {
+			log.Printf("storage.GetUsersByUsernames: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if err = s.storage.UpdateComment(comment); err != nil {
+			log.Printf("storage.UpdateComment: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) deleteCommentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		comment, ok := s.taskComment(w, r, task)
+		if !ok {
+			return
+		}
+
+		if comment.AuthorID != user.ID && user.Role != adminRole {
+			code := http.StatusForbidden
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if err := s.storage.DeleteComment(comment.ID); err != nil {
+			log.Printf("storage.DeleteComment: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) createAttachmentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		attachment, data, err := ReadAttachment(w, r, &s.config.Attachment)
+		switch {
+		case errors.Is(err, ErrAttachmentTooLarge):
+			code := http.StatusRequestEntityTooLarge
+			http.Error(w, http.StatusText(code), code)
+			return
+		case errors.Is(err, ErrAttachmentType):
+			code := http.StatusUnsupportedMediaType
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("ReadAttachment: %s\n", err.Error())
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		attachment.ID = uuid.New()
+		attachment.TaskID = task.ID
+		attachment.UploaderID = user.ID
+		attachment.StorageKey = fmt.Sprintf("tasks/%s/%s", task.ID, attachment.ID)
+
+		err = s.blobs.Put(
+			r.Context(),
+			attachment.StorageKey,
+			bytes.NewReader(data),
+			attachment.Size,
+			attachment.ContentType,
+		)
+		if err != nil {
+			log.Printf("blobs.Put: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.storage.CreateAttachment(attachment)
+		if err != nil {
+			log.Printf("storage.CreateAttachment: %s\n", err.Error())
+
+			// Don't leave the blob orphaned
+			if err = s.blobs.Delete(r.Context(), attachment.StorageKey); err != nil {
+				log.Printf("blobs.Delete: %s\n", err.Error())
+			}
+
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(AttachmentCreateResponse{ID: attachment.ID})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) getAttachmentsHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		attachments, err := s.storage.GetAttachmentsByTaskID(task.ID)
+		if err != nil {
+			log.Printf("storage.GetAttachmentsByTaskID: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(attachments)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) downloadAttachmentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		attachment, ok := s.taskAttachment(w, r, task)
+		if !ok {
+			return
+		}
+
+		body, err := s.blobs.Get(r.Context(), attachment.StorageKey)
+		switch {
+		case errors.Is(err, ErrBlobNotFound):
+			log.Printf("blobs.Get: attachment %s has no blob\n", attachment.ID)
+			code := http.StatusNotFound
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("blobs.Get: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set(
+			"Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}),
+		)
+
+		_, err = io.Copy(w, body)
+		if err != nil {
+			log.Printf("io.Copy: %s\n", err.Error())
+		}
+	}
+}
+
+func (s *Service) deleteAttachmentHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, task, ok := s.visibleTask(w, r)
+		if !ok {
+			return
+		}
+
+		attachment, ok := s.taskAttachment(w, r, task)
+		if !ok {
+			return
+		}
+
+		if attachment.UploaderID != user.ID && !canEditTask(user, task) {
+			code := http.StatusForbidden
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err := s.storage.DeleteAttachment(attachment.ID)
+		if err != nil {
+			log.Printf("storage.DeleteAttachment: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		// The metadata is gone already, so a leftover blob is only wasted space
+		err = s.blobs.Delete(r.Context(), attachment.StorageKey)
+		if err != nil {
+			log.Printf("blobs.Delete: %s\n", err.Error())
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) streamTasksHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			code := http.StatusNotImplemented
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+		sub := s.hub.Subscribe(userID)
+		defer s.hub.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(s.config.Stream.HeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				// Comment lines keep proxies from closing an idle connection
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case event, open := <-sub.Events():
+				if !open {
+					return
+				}
+
+				_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+				if err != nil {
+					return
+				}
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// healthHandler reports the event bus connection along with the service status. The service
+// is unavailable while the event bus is down, even though the events wait in the outbox
+func (s *Service) healthHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusOK
+
+		state := s.client.State()
+		if state != RabbitConnected {
+			code = http.StatusServiceUnavailable
+		}
+
+		resp, err := json.Marshal(HealthResponse{Status: http.StatusText(code), EventBus: state})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		w.WriteHeader(code)
+		_, _ = w.Write(resp)
+	}
+}
+
+// prepareImport turns the import rows into tasks, resolving the usernames and
+// picking the assignees. The tasks of the failed rows are nil
+func (s *Service) prepareImport(user *User, rows []*TaskImportRow) ([]*Task, *TaskImportReport, error) {
+	usernames := make([]string, 0, 2*len(rows))
+	for _, row := range rows {
+		if row.Author != "" {
+			usernames = append(usernames, row.Author)
+		}
+		if row.Assignee != "" {
+			usernames = append(usernames, row.Assignee)
+		}
+	}
+
+	users, err := s.storage.GetUsersByUsernames(usernames)
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage.GetUsersByUsernames: %w", err)
+	}
+
+	usersByName := make(map[string]*User, len(users))
+	for _, u := range users {
+		usersByName[u.Username] = u
+	}
+
+	workload, err := s.storage.GetWorkload()
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage.GetWorkload: %w", err)
+	}
+
+	applyCapacity(workload, s.config.Assignment.MaxOpenTasks)
+
+	report := &TaskImportReport{
+		Total: len(rows),
+		Rows:  make([]*TaskImportRowResult, 0, len(rows)),
+	}
+	tasks := make([]*Task, 0, len(rows))
+
+	rand.Seed(time.Now().Unix())
+	for i, row := range rows {
+		result := &TaskImportRowResult{Row: i + 1}
+		report.Rows = append(report.Rows, result)
+
+		var task *Task
+
+		task, err = s.importTask(user, row, usersByName, workload)
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, report, nil
+}
+
+func (s *Service) importTask(
+	user *User,
+	row *TaskImportRow,
+	usersByName map[string]*User,
+	workload []*Workload,
+) (*Task, error) {
+	if row.err != nil {
+		return nil, row.err
+	}
+
+	task := &Task{
+		Title:       row.Title,
+		Description: row.Description,
+		AuthorID:    user.ID,
+		Labels:      row.Labels,
+		Priority:    row.Priority,
+		DueAt:       row.DueAt,
+	}
+
+	if row.Author != "" {
+		author, ok := usersByName[row.Author]
+		if !ok {
+			return nil, fmt.Errorf("%w: author %s", ErrUnknownUsername, row.Author)
+		}
+
+		task.AuthorID = author.ID
+	}
+
+	err := prepareTask(task)
+	if err != nil {
+		return nil, err
+	}
+
+	if row.Assignee == "" {
+		assignTask(task, pickAvailableAssignee(workload, task, s.config.Assignment.MatchSkills))
+
+		return task, nil
+	}
+
+	assignee, ok := usersByName[row.Assignee]
+	if !ok {
+		return nil, fmt.Errorf("%w: assignee %s", ErrUnknownUsername, row.Assignee)
+	}
+
+	if assignee.Role != workerRole {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAssignee, row.Assignee)
+	}
+
+	// An explicit assignee takes the task over the limit, it's still counted for the rows below
+	task.AssigneeID = assignee.ID
+	holdAssignee(workload, task)
+
+	return task, nil
+}
+
+// newTaskBulk validates the bulk request, the tasks are not looked at yet
+func (s *Service) newTaskBulk(user *User, req *TaskBulkRequest) (*TaskBulk, error) {
+	if len(req.TaskIDs) == 0 || len(req.TaskIDs) > taskBulkMaxItems {
+		return nil, fmt.Errorf("%w: %d tasks", ErrInvalidBulkRequest, len(req.TaskIDs))
+	}
+
+	bulk := &TaskBulk{
+		Operation: req.Operation,
+		Mode:      req.Mode,
+		ActorID:   user.ID,
+		Reason:    strings.TrimSpace(req.Reason),
+	}
+
+	switch bulk.Mode {
+	case "":
+		bulk.Mode = transactionalBulkMode
+	case transactionalBulkMode, bestEffortBulkMode:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %s", ErrInvalidBulkRequest, bulk.Mode)
+	}
+
+	switch bulk.Operation {
+	case completeBulkOperation:
+	case cancelBulkOperation:
+		if bulk.Reason == "" {
+			return nil, fmt.Errorf("%w: no reason to cancel", ErrInvalidBulkRequest)
+		}
+	case reassignBulkOperation:
+		assignee, err := s.storage.GetUserByID(req.AssigneeID)
+		if errors.Is(err, dbr.ErrNotFound) {
+			return nil, ErrInvalidAssignee
+		}
+		if err != nil {
+			return nil, fmt.Errorf("storage.GetUserByID: %w", err)
+		}
+
+		if assignee.Role != workerRole {
+			return nil, ErrInvalidAssignee
+		}
+
+		bulk.AssigneeID = assignee.ID
+	case setLabelsBulkOperation:
+		bulk.Labels = NormalizeTags(req.Labels)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBulkOperation, bulk.Operation)
+	}
+
+	return bulk, nil
+}
+
+// visibleTaskFilter parses the task listing filter and narrows it down to the
+// tasks the current user is allowed to look through, writing an error response
+// and returning false if the user can't list tasks at all
+func (s *Service) visibleTaskFilter(w http.ResponseWriter, r *http.Request) (*TaskFilter, bool) {
+	userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+	user, err := s.storage.GetUserByID(userID)
+	if err != nil {
+		log.Printf("storage.GetUserByID: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	filter, err := ParseTaskFilter(r)
+	if err != nil {
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	// Workers are limited to their own tasks, managers may look through everyone's
+	switch user.Role {
+	case workerRole:
+		filter.AssigneeID = uuid.NullUUID{UUID: userID, Valid: true}
+	case adminRole, managerRole:
+	default:
+		code := http.StatusForbidden
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	return filter, true
+}
+
+// visibleTask loads the current user and the task from the URL, writing an error
+// response and returning false if the task can't be shown to the user
+func (s *Service) visibleTask(w http.ResponseWriter, r *http.Request) (*User, *Task, bool) {
+	taskID, err := uuid.Parse(chi.URLParam(r, requestParamTaskID))
+	if err != nil {
+		log.Printf("uuid.Parse: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, nil, false
+	}
+
+	userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+	user, err := s.storage.GetUserByID(userID)
+	if err != nil {
+		log.Printf("storage.GetUserByID: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, nil, false
+	}
+
+	task, err := s.storage.GetTaskByID(taskID)
+	switch {
+	case errors.Is(err, dbr.ErrNotFound):
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return nil, nil, false
+	case err != nil:
+		log.Printf("storage.GetTaskByID: %s\n", err.Error())
+		code := http.StatusInternalServerError
+		http.Error(w, http.StatusText(code), code)
+		return nil, nil, false
+	}
+
+	if !canViewTask(user, task) {
+		code := http.StatusForbidden
+		http.Error(w, http.StatusText(code), code)
+		return nil, nil, false
+	}
+
+	return user, task, true
+}
+
+// checkRelatedTasks makes sure the user may link a task to the related ones. The subtasks hold
+// the parent back from the auto-completion, so the parent has to be editable by the user, while
+// the blockers only have to be visible. A task the user can't see is reported as missing,
+// so the IDs of the other users' tasks can't be probed
+func (s *Service) checkRelatedTasks(user *User, parentID uuid.NullUUID, blockerIDs []uuid.UUID) error {
+	ids := blockerIDs
+	if parentID.Valid {
+		ids = append([]uuid.UUID{parentID.UUID}, blockerIDs...)
+	}
+
+	tasks, err := s.storage.GetTasksByIDs(uniqueIDs(ids))
+	if err != nil {
+		return fmt.Errorf("storage.GetTasksByIDs: %w", err)
+	}
+
+	related := make(map[uuid.UUID]*Task, len(tasks))
+	for _, task := range tasks {
+		related[task.ID] = task
+	}
+
+	for _, id := range ids {
+		task, ok := related[id]
+		if !ok || !canViewTask(user, task) {
+			return fmt.Errorf("%w: %s", ErrRelatedTaskNotFound, id)
+		}
+	}
+
+	if parentID.Valid && !canEditTask(user, related[parentID.UUID]) {
+		return fmt.Errorf("%w: %s", ErrTaskForbidden, parentID.UUID)
+	}
+
+	return nil
+}
+
+// taskComment loads a live comment from the URL that belongs to the task
+func (s *Service) taskComment(w http.ResponseWriter, r *http.Request, task *Task) (*Comment, bool) {
+	commentID, err := uuid.Parse(chi.URLParam(r, requestParamCommentID))
+	if err != nil {
+		log.Printf("uuid.Parse: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	comment, err := s.storage.GetCommentByID(commentID)
+	switch {
+	case errors.Is(err, dbr.ErrNotFound):
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	case err != nil:
+		log.Printf("storage.GetCommentByID: %s\n", err.Error())
+		code := http.StatusInternalServerError
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	if comment.TaskID != task.ID || comment.DeletedAt != nil {
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	return comment, true
+}
+
+// taskAttachment loads the attachment from the URL that belongs to the task
+func (s *Service) taskAttachment(w http.ResponseWriter, r *http.Request, task *Task) (*Attachment, bool) {
+	attachmentID, err := uuid.Parse(chi.URLParam(r, requestParamAttachmentID))
+	if err != nil {
+		log.Printf("uuid.Parse: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	attachment, err := s.storage.GetAttachment(task.ID, attachmentID)
+	switch {
+	case errors.Is(err, dbr.ErrNotFound):
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	case err != nil:
+		log.Printf("storage.GetAttachment: %s\n", err.Error())
+		code := http.StatusInternalServerError
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	return attachment, true
+}
+
+// resolveMentions maps @username mentions in a text onto the known users
+func (s *Service) resolveMentions(text string) ([]uuid.UUID, error) {
+	users, err := s.storage.GetUsersByUsernames(ExtractMentions(text))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	return ids, nil
+}
+
+func (s *Service) createTemplateHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		req := new(TemplateRequest)
+
+		err := BodyParser(w, r, req)
+		if err != nil {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if req.Priority == "" {
+			req.Priority = normalPriority
+		}
+
+		schedule, err := ParseCron(req.Schedule)
+		if err != nil || !validPriority(req.Priority) || req.DueIn < 0 ||
+			utf8.RuneCountInString(strings.TrimSpace(req.Title)) > taskTitleMaxLength {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		nextRunAt := schedule.Next(time.Now().UTC())
+		if nextRunAt.IsZero() {
+			code := http.StatusUnprocessableEntity
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		template := &TaskTemplate{
+			AuthorID:    user.ID,
+			Title:       strings.TrimSpace(req.Title),
+			Description: req.Description,
+			Labels:      NormalizeTags(req.Labels),
+			Priority:    req.Priority,
+			Schedule:    strings.TrimSpace(req.Schedule),
+			DueIn:       req.DueIn,
+			NextRunAt:   nextRunAt,
+		}
+
+		err = s.storage.CreateTemplate(template)
+		if err != nil {
+			log.Printf("storage.CreateTemplate: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(TemplateCreateResponse{
+			ID:        template.ID,
+			NextRunAt: template.NextRunAt,
+		})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) getTemplatesHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		templates, err := s.storage.GetTemplates()
+		if err != nil {
+			log.Printf("storage.GetTemplates: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(templates)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+func (s *Service) deleteTemplateHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ok := s.managerUser(w, r)
+		if !ok {
+			return
+		}
+
+		templateID, err := uuid.Parse(chi.URLParam(r, requestParamTemplateID))
+		if err != nil {
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		err = s.storage.DeactivateTemplate(templateID)
+		switch {
+		case errors.Is(err, dbr.ErrNotFound):
+			code := http.StatusNotFound
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("storage.DeactivateTemplate: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+// getDeadLettersHandler lists the parked messages without taking them off the queue
+func (s *Service) getDeadLettersHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminUser(w, r) {
+			return
+		}
+
+		limit, err := ParseDeadLetterLimit(r)
+		if err != nil {
+			code := http.StatusBadRequest
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		letters, total, err := s.client.PeekDeadLetters(RabbitQueue, limit)
+		if err != nil {
+			log.Printf("client.PeekDeadLetters: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(DeadLetterListResponse{Total: total, Letters: letters})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+// replayDeadLetterHandler returns the parked message to the queue with a fresh set of retries
+func (s *Service) replayDeadLetterHandler() func(w http.ResponseWriter, r *http.Request) {
+	return s.takeDeadLetterHandler(func(msg *amqp.Delivery) error {
+		return s.client.Republish(RabbitQueue, msg, amqp.Table{RabbitHeaderRetryCount: int32(0)})
+	})
+}
+
+func (s *Service) dropDeadLetterHandler() func(w http.ResponseWriter, r *http.Request) {
+	return s.takeDeadLetterHandler(func(msg *amqp.Delivery) error {
+		log.Printf("Dropping %s event %s\n", msg.Type, msg.MessageId)
+		return nil
+	})
+}
+
+// takeDeadLetterHandler takes the parked message from the URL off the queue, handing it to the function first
+func (s *Service) takeDeadLetterHandler(
+	fn func(msg *amqp.Delivery) error,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminUser(w, r) {
+			return
+		}
+
+		err := s.client.TakeDeadLetter(RabbitQueue, chi.URLParam(r, requestParamEventID), fn)
+		switch {
+		case errors.Is(err, ErrDeadLetterNotFound):
+			code := http.StatusNotFound
+			http.Error(w, http.StatusText(code), code)
+			return
+		case err != nil:
+			log.Printf("client.TakeDeadLetter: %s\n", err.Error())
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		resp, err := json.Marshal(Response{Status: http.StatusText(http.StatusOK)})
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		_, _ = w.Write(resp)
+	}
+}
+
+// adminUser tells whether the request user is an admin, writing an error response if not
+func (s *Service) adminUser(w http.ResponseWriter, r *http.Request) bool {
+	userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+	user, err := s.storage.GetUserByID(userID)
+	if err != nil {
+		log.Printf("storage.GetUserByID: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	if user.Role != adminRole {
+		code := http.StatusForbidden
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	return true
+}
+
+// managerUser gets the request user, writing an error response
+// if the user is not allowed to manage other people's work
+func (s *Service) managerUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	userID, _ := r.Context().Value(requestParamUserID).(uuid.UUID)
+
+	user, err := s.storage.GetUserByID(userID)
+	if err != nil {
+		log.Printf("storage.GetUserByID: %s\n", err.Error())
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	if user.Role != adminRole && user.Role != managerRole {
+		code := http.StatusForbidden
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// transitTask applies a manager-only status change with a reason to the task
+// from the URL, writing an error response if the change isn't possible
+func (s *Service) transitTask(w http.ResponseWriter, r *http.Request, action TaskAction) bool {
+	user, task, ok := s.visibleTask(w, r)
+	if !ok {
+		return false
+	}
+
+	if user.Role != adminRole && user.Role != managerRole {
+		code := http.StatusForbidden
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	version, err := ParseIfMatch(r)
+	switch {
+	case errors.Is(err, ErrWeakIfMatch):
+		code := http.StatusPreconditionFailed
+		http.Error(w, http.StatusText(code), code)
+		return false
+	case err != nil:
+		code := http.StatusBadRequest
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	req := new(TaskTransitionRequest)
+
+	err = BodyParser(w, r, req)
+	if err != nil || strings.TrimSpace(req.Reason) == "" {
+		code := http.StatusUnprocessableEntity
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	prev, _, err := s.storage.UpdateTaskStatus(&TaskTransition{
+		TaskID:  task.ID,
+		ActorID: user.ID,
+		Action:  action,
+		Reason:  req.Reason,
+		Version: version,
+	})
+	switch {
+	case errors.Is(err, ErrTaskVersionConflict):
+		code := http.StatusPreconditionFailed
+		http.Error(w, http.StatusText(code), code)
+		return false
+	case errors.Is(err, ErrTaskStatusConflict):
+		code := http.StatusConflict
+		http.Error(w, http.StatusText(code), code)
+		return false
+	case err != nil:
+		log.Printf("storage.UpdateTaskStatus: %s\n", err.Error())
+		code := http.StatusInternalServerError
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+
+	w.Header().Set(HeaderETag, FormatETag(prev.Version+1))
+
+	return true
+}
+
+// createTask assigns a new task to a random worker, stores it and notifies about it.
+// Both the API and the templates scheduler create tasks this way
+func (s *Service) createTask(task *Task) error {
+	err := prepareTask(task)
+	if err != nil {
+		return err
+	}
+
+	// Get a worker below the limit for the task randomly, the task waits unassigned if there is none
+	workload, err := s.storage.GetWorkload()
+	if err != nil {
+		return fmt.Errorf("storage.GetWorkload: %w", err)
+	}
+
+	applyCapacity(workload, s.config.Assignment.MaxOpenTasks)
+
+	rand.Seed(time.Now().Unix())
+
+	for {
+		assignTask(task, pickAvailableAssignee(workload, task, s.config.Assignment.MatchSkills))
+
+		err = s.storage.CreateTask(task, s.config.Assignment.MaxOpenTasks)
+		if !errors.Is(err, ErrWorkerAtCapacity) {
+			break
+		}
+
+		// Another request has taken the last slot of the worker, so another one is picked
+		fillCapacity(workload, task.AssigneeID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("storage.CreateTask: %w", err)
+	}
+
+	return nil
+}
+
+// assignTasks offers the open tasks to the workers below their limits, the most
+// urgent ones first. The tasks no worker has room for are left unassigned
+func (s *Service) assignTasks(tasks []*Task, actorID uuid.UUID) error {
+	workload, err := s.storage.GetWorkload()
+	if err != nil {
+		return fmt.Errorf("storage.GetWorkload: %w", err)
+	}
+
+	if len(workload) == 0 {
+		return ErrNoWorkers
+	}
+
+	applyCapacity(workload, s.config.Assignment.MaxOpenTasks)
+
+	// The tasks are up for grabs again, so they don't count against their assignees
+	for _, task := range tasks {
+		releaseAssignee(workload, task)
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return priorityRank(tasks[i].Priority) > priorityRank(tasks[j].Priority)
+	})
+
+	rand.Seed(time.Now().Unix())
+	for _, task := range tasks {
+		err = s.reassignTask(workload, task, actorID)
+		if errors.Is(err, ErrTaskVersionConflict) {
+			// The task has been completed or changed otherwise since it was read
+			log.Printf("task %s changed during the assignment, skipping\n", task.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("storage.UpdateTaskAssignee: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// reassignTask gives the task to a worker below the limit. The limit is checked once again along
+// with the update, so the worker filled up by another request or replica is passed over
+func (s *Service) reassignTask(workload []*Workload, task *Task, actorID uuid.UUID) error {
+	prevAssigneeID := task.AssigneeID
+
+	for {
+		assignTask(task, pickAvailableAssignee(workload, task, s.config.Assignment.MatchSkills))
+		if task.AssigneeID == uuid.Nil && prevAssigneeID == uuid.Nil {
+			return nil
+		}
+
+		err := s.storage.UpdateTaskAssignee(task, actorID, s.config.Assignment.MaxOpenTasks)
+		if !errors.Is(err, ErrWorkerAtCapacity) {
+			return err
+		}
+
+		fillCapacity(workload, task.AssigneeID)
+	}
+}
+
+// assignTask gives the task to the picked worker, no worker leaves the open task unassigned
+func assignTask(task *Task, load *Workload) {
+	if load == nil {
+		task.AssigneeID = uuid.Nil
+		if task.Status == createdStatus {
+			task.Status = unassignedStatus
+		}
+
+		return
+	}
+
+	task.AssigneeID = load.UserID
+	if task.Status == unassignedStatus {
+		task.Status = createdStatus
+	}
+}
+
+// prepareTask normalizes and validates a new task, the assignee is left as it is
+func prepareTask(task *Task) error {
+	task.Status = createdStatus
+	task.OverdueAt = nil
+	task.Title = strings.TrimSpace(task.Title)
+	task.Labels = NormalizeTags(task.Labels)
+	task.BlockedBy = uniqueIDs(task.BlockedBy)
+	task.SubtaskIDs = nil
+
+	if task.Priority == "" {
+		task.Priority = normalPriority
+	}
+
+	if !validPriority(task.Priority) {
+		return ErrInvalidPriority
+	}
+
+	if utf8.RuneCountInString(task.Title) > taskTitleMaxLength {
+		return ErrTaskTitleTooLong
+	}
+
+	return nil
 }
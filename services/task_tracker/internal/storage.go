@@ -1,14 +1,20 @@
 package internal
 
 import (
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/gocraft/dbr/v2"
 	"github.com/google/uuid"
-	_ "github.com/lib/pq" // Driver
+	"github.com/lib/pq" // Driver
 	"github.com/pressly/goose/v3"
+	"github.com/vashc/async_arch_course/pkg/events"
 )
 
 //go:embed migrations
@@ -59,10 +65,13 @@ func (s *Storage) Close() error {
 	return s.sess.Close()
 }
 
-func (s *Storage) CreateUser(user *User) error {
+// CreateUser stores the user from the event. The user may be there already if the update
+// of the user has come first, so the creation is skipped then
+func (s *Storage) CreateUser(user *User, event *InboxEvent) error {
 	query := `
-INSERT INTO users(id, username, role)
-VALUES (?, ?, ?);
+INSERT INTO users(id, username, role, skills)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING;
 `
 
 	tx, err := s.sess.Begin()
@@ -71,11 +80,17 @@ VALUES (?, ?, ?);
 	}
 	defer tx.RollbackUnlessCommitted()
 
+	err = recordInboxEvent(tx, event)
+	if err != nil {
+		return err
+	}
+
 	err = tx.InsertBySql(
 		query,
 		user.ID,
 		user.Username,
 		user.Role,
+		user.Skills,
 	).Load(user)
 	if err != nil {
 		return err
@@ -84,6 +99,100 @@ VALUES (?, ?, ?);
 	return tx.Commit()
 }
 
+// UpsertUser replicates the user profile, creating the user if it's not known yet
+func (s *Storage) UpsertUser(user *User, event *InboxEvent) error {
+	query := `
+INSERT INTO users(id, username, role, skills)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE
+SET username = EXCLUDED.username,
+    role = EXCLUDED.role,
+    skills = EXCLUDED.skills,
+    updated_at = now();
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = recordInboxEvent(tx, event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.InsertBySql(
+		query,
+		user.ID,
+		user.Username,
+		user.Role,
+		user.Skills,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// recordInboxEvent marks the event processed in the transaction of its side effect.
+// It returns ErrDuplicateEvent if the event has been processed already. The events
+// without an ID are not recorded, there is nothing to recognize them by
+func recordInboxEvent(tx *dbr.Tx, event *InboxEvent) error {
+	query := `
+INSERT INTO inbox(event_id, event_type)
+VALUES (?, ?)
+ON CONFLICT (event_id) DO NOTHING;
+`
+
+	if event == nil {
+		return nil
+	}
+
+	res, err := tx.InsertBySql(query, event.EventID, event.EventType).Exec()
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateEvent, event.EventID)
+	}
+
+	return nil
+}
+
+// PruneInboxEvents forgets the events processed before the time
+func (s *Storage) PruneInboxEvents(before time.Time) (int64, error) {
+	query := `
+DELETE FROM inbox
+WHERE processed_at < ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.DeleteBySql(query, before).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
 func (s *Storage) GetUserByID(id uuid.UUID) (user *User, err error) {
 	query := `
 SELECT *
@@ -105,26 +214,21 @@ WHERE id = ?;
 	return user, nil
 }
 
-func (s *Storage) CreateTask(task *Task) error {
-	query := `
-INSERT INTO tasks(description, status, author_id, assignee_id)
-VALUES (?, ?, ?, ?)
-RETURNING id;
-`
-
+// CreateTask stores the task. The open tasks of the assignee are recounted under the lock
+// of the assignee, ErrWorkerAtCapacity is returned if the worker has been filled up meanwhile
+func (s *Storage) CreateTask(task *Task, maxOpenTasks int64) error {
 	tx, err := s.sess.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.RollbackUnlessCommitted()
 
-	err = tx.InsertBySql(
-		query,
-		task.Description,
-		task.Status,
-		task.AuthorID,
-		task.AssigneeID,
-	).Load(task)
+	err = lockCapacity(tx, task.AssigneeID, uuid.Nil, maxOpenTasks)
+	if err != nil {
+		return err
+	}
+
+	err = insertTask(tx, task)
 	if err != nil {
 		return err
 	}
@@ -132,24 +236,60 @@ RETURNING id;
 	return tx.Commit()
 }
 
-func (s *Storage) UpdateTaskStatus(taskID uuid.UUID, status TaskStatus) error {
-	query := `
-UPDATE tasks
-SET status = ?
-WHERE id = ?;
-`
+// CreateTasks stores a batch of tasks, either all of them or none
+func (s *Storage) CreateTasks(tasks []*Task) error {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	for _, task := range tasks {
+		err = insertTask(tx, task)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTaskStatus moves a task along the status state machine and returns the
+// task as it was before. ErrTaskStatusConflict is returned if the task is not in
+// a status the action applies to, ErrTaskVersionConflict if the expected version
+// of the task is outdated and ErrTaskBlocked if the task still has open blockers.
+// Completing the last open subtask also completes the parents set to auto-complete,
+// they are returned in their previous state as well
+func (s *Storage) UpdateTaskStatus(transition *TaskTransition) (prev *Task, parents []*Task, err error) {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	prev, parents, err = applyTaskTransition(tx, transition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return prev, parents, tx.Commit()
+}
 
+// UpdateTaskAssignee reassigns the task unless it has been changed since its
+// version was read, in which case ErrTaskVersionConflict is returned
+func (s *Storage) UpdateTaskAssignee(task *Task, actorID uuid.UUID, maxOpenTasks int64) error {
 	tx, err := s.sess.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.RollbackUnlessCommitted()
 
-	_, err = tx.UpdateBySql(
-		query,
-		status,
-		taskID,
-	).Exec()
+	err = lockCapacity(tx, task.AssigneeID, task.ID, maxOpenTasks)
+	if err != nil {
+		return err
+	}
+
+	err = updateTaskAssignee(tx, task, actorID)
 	if err != nil {
 		return err
 	}
@@ -157,24 +297,43 @@ WHERE id = ?;
 	return tx.Commit()
 }
 
-func (s *Storage) UpdateTaskAssignee(taskID, assigneeID uuid.UUID) error {
-	query := `
-UPDATE tasks
-SET assignee_id = ?
-WHERE id = ?;
-`
+// UpdateTasksInBulk applies the bulk operation to the items one by one. Every item
+// gets a transaction of its own in the best-effort mode, so the failed ones don't
+// hold the rest back. In the transactional mode either all items are applied or
+// none, the first failed item aborts the batch with ErrTaskBulkAborted
+func (s *Storage) UpdateTasksInBulk(bulk *TaskBulk) error {
+	if bulk.Mode == transactionalBulkMode {
+		tx, err := s.sess.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.RollbackUnlessCommitted()
+
+		for _, item := range bulk.Items {
+			item.Err = applyTaskBulkItem(tx, bulk, item)
+			if item.Err != nil {
+				return ErrTaskBulkAborted
+			}
+		}
+
+		return tx.Commit()
+	}
+
+	for _, item := range bulk.Items {
+		item.Err = s.updateTaskInBulk(bulk, item)
+	}
 
+	return nil
+}
+
+func (s *Storage) updateTaskInBulk(bulk *TaskBulk, item *TaskBulkItem) error {
 	tx, err := s.sess.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.RollbackUnlessCommitted()
 
-	_, err = tx.UpdateBySql(
-		query,
-		assigneeID,
-		taskID,
-	).Exec()
+	err = applyTaskBulkItem(tx, bulk, item)
 	if err != nil {
 		return err
 	}
@@ -200,9 +359,41 @@ WHERE id = ?;
 		return nil, err
 	}
 
+	err = loadTaskRelations(tx, []*Task{task})
+	if err != nil {
+		return nil, err
+	}
+
 	return task, nil
 }
 
+func (s *Storage) GetTasksByIDs(ids []uuid.UUID) (tasks []*Task, err error) {
+	query := `
+SELECT *
+FROM tasks
+WHERE id IN ?;
+`
+
+	tasks = make([]*Task, 0)
+
+	if len(ids) == 0 {
+		return tasks, nil
+	}
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.SelectBySql(query, ids).Load(&tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
 func (s *Storage) GetUsersByRole(role Role) (users []*User, err error) {
 	query := `
 SELECT *
@@ -226,11 +417,16 @@ WHERE role = ?;
 	return users, nil
 }
 
-func (s *Storage) GetTasksByStatus(status TaskStatus) (tasks []*Task, err error) {
+// GetWorkload counts the open tasks of every worker. The unassigned tasks
+// belong to no one, so only the created ones are counted
+func (s *Storage) GetWorkload() (workload []*Workload, err error) {
 	query := `
-SELECT *
-FROM tasks
-WHERE status = ?;
+SELECT u.id, u.username, u.skills, u.max_open_tasks, count(t.id) AS open_tasks
+FROM users u
+LEFT JOIN tasks t ON t.assignee_id = u.id AND t.status = ?
+WHERE u.role = ?
+GROUP BY u.id
+ORDER BY u.username;
 `
 
 	tx, err := s.sess.Begin()
@@ -239,21 +435,54 @@ WHERE status = ?;
 	}
 	defer tx.RollbackUnlessCommitted()
 
-	tasks = make([]*Task, 0)
+	workload = make([]*Workload, 0)
 
-	_, err = tx.SelectBySql(query, status).Load(&tasks)
+	_, err = tx.SelectBySql(query, createdStatus, workerRole).Load(&workload)
 	if err != nil {
 		return nil, err
 	}
 
-	return tasks, nil
+	return workload, nil
+}
+
+// SetWorkerCapacity sets the open tasks limit of a worker, the global limit
+// applies to the worker again once it's null
+func (s *Storage) SetWorkerCapacity(userID uuid.UUID, maxOpenTasks dbr.NullInt64) error {
+	query := `
+UPDATE users
+SET max_open_tasks = ?, updated_at = now()
+WHERE id = ? AND role = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.UpdateBySql(query, maxOpenTasks, userID, workerRole).Exec()
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return dbr.ErrNotFound
+	}
+
+	return tx.Commit()
 }
 
-func (s *Storage) GetTasksByAssigneeID(assigneeID uuid.UUID) (tasks []*Task, err error) {
+func (s *Storage) GetTasksByStatus(statuses ...TaskStatus) (tasks []*Task, err error) {
 	query := `
 SELECT *
 FROM tasks
-WHERE assignee_id = ?;
+WHERE status IN ?
+ORDER BY created_at;
 `
 
 	tx, err := s.sess.Begin()
@@ -264,10 +493,1760 @@ WHERE assignee_id = ?;
 
 	tasks = make([]*Task, 0)
 
-	_, err = tx.SelectBySql(query, assigneeID).Load(&tasks)
+	_, err = tx.SelectBySql(query, statuses).Load(&tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (s *Storage) GetTasks(filter *TaskFilter) (tasks []*Task, err error) {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	stmt := tx.Select("*").
+		From("tasks").
+		OrderAsc("created_at")
+
+	applyTaskFilter(stmt, filter)
+
+	tasks = make([]*Task, 0)
+
+	_, err = stmt.Load(&tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadTaskRelations(tx, tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// SearchTasks looks tasks up by words of their titles, descriptions, labels and
+// comments, the best matches go first. The filter narrows the results down just
+// like it does for the listing
+func (s *Storage) SearchTasks(text string, filter *TaskFilter, limit uint64) (tasks []*Task, err error) {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	stmt := tx.Select("*").
+		From("tasks").
+		Where("search_vector @@ websearch_to_tsquery(?, ?)", taskSearchConfig, text).
+		Limit(limit)
+
+	stmt.Order = append(
+		stmt.Order,
+		dbr.Expr("ts_rank(search_vector, websearch_to_tsquery(?, ?)) DESC", taskSearchConfig, text),
+	)
+	stmt.OrderDesc("created_at")
+
+	applyTaskFilter(stmt, filter)
+
+	tasks = make([]*Task, 0)
+
+	_, err = stmt.Load(&tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadTaskRelations(tx, tasks)
 	if err != nil {
 		return nil, err
 	}
 
 	return tasks, nil
 }
+
+// ExportTasks walks through the filtered tasks one by one, so that the export
+// doesn't have to keep all of them in memory
+func (s *Storage) ExportTasks(ctx context.Context, filter *TaskFilter, fn func(*TaskExportRecord) error) error {
+	tx, err := s.sess.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	stmt := tx.Select(
+		"tasks.id",
+		"tasks.created_at",
+		"tasks.title",
+		"tasks.description",
+		"tasks.status",
+		"authors.username AS author",
+		"assignees.username AS assignee",
+		"tasks.labels",
+		"tasks.priority",
+		"tasks.due_at",
+	).
+		From("tasks").
+		Join(dbr.I("users").As("authors"), "authors.id = tasks.author_id").
+		// The unassigned tasks have no assignee to join
+		LeftJoin(dbr.I("users").As("assignees"), "assignees.id = tasks.assignee_id").
+		OrderAsc("tasks.created_at")
+
+	applyTaskFilter(stmt, filter)
+
+	iter, err := stmt.IterateContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.Next() {
+		record := new(TaskExportRecord)
+
+		err = iter.Scan(record)
+		if err != nil {
+			return err
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+func applyTaskFilter(stmt *dbr.SelectStmt, filter *TaskFilter) {
+	if filter.AssigneeID.Valid {
+		stmt.Where(dbr.Eq("assignee_id", filter.AssigneeID.UUID))
+	}
+	if filter.DueAfter != nil {
+		stmt.Where(dbr.Gte("due_at", *filter.DueAfter))
+	}
+	if filter.DueBefore != nil {
+		stmt.Where(dbr.Lt("due_at", *filter.DueBefore))
+	}
+	if len(filter.Labels) > 0 {
+		stmt.Where("labels @> ?", pq.StringArray(filter.Labels))
+	}
+	if filter.Priority != "" {
+		stmt.Where(dbr.Eq("priority", filter.Priority))
+	}
+	if filter.Overdue != nil {
+		if *filter.Overdue {
+			stmt.Where("overdue_at IS NOT NULL")
+		} else {
+			stmt.Where("overdue_at IS NULL")
+		}
+	}
+}
+
+// MarkOverdueTasks flags open tasks which are past their due date. Every task is
+// returned by it only once, so the caller can safely notify about each of them
+func (s *Storage) MarkOverdueTasks() (tasks []*Task, err error) {
+	query := `
+UPDATE tasks
+SET overdue_at = now(), version = version + 1, updated_at = now()
+WHERE due_at < now() AND overdue_at IS NULL AND status IN ?
+RETURNING *;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	tasks = make([]*Task, 0)
+
+	_, err = tx.SelectBySql(query, openStatuses()).Load(&tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		// Create exchange message in a queue
+		taskOverdue := &events.TaskOverdueV1{
+			TaskID:     task.ID,
+			AssigneeID: task.AssigneeID,
+			DueAt:      *task.DueAt,
+		}
+
+		err = insertOutboxEvent(tx, taskOverdue, task.AssigneeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tasks, tx.Commit()
+}
+
+// AddTaskDependency marks the task as blocked by another one. It returns
+// ErrTaskDependencyCycle if the blocker already depends on the task
+func (s *Storage) AddTaskDependency(task *Task, blockerID uuid.UUID) error {
+	cycleQuery := `
+WITH RECURSIVE blockers(id) AS (
+    SELECT blocker_id
+    FROM task_dependencies
+    WHERE task_id = ?
+    UNION
+    SELECT d.blocker_id
+    FROM task_dependencies d
+    JOIN blockers b ON d.task_id = b.id
+)
+SELECT EXISTS(SELECT 1 FROM blockers WHERE id = ?);
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	if task.ID == blockerID {
+		return ErrTaskDependencyCycle
+	}
+
+	err = checkTasksExist(tx, []uuid.UUID{blockerID})
+	if err != nil {
+		return err
+	}
+
+	// Serialize graph changes, otherwise two concurrent inserts may close a cycle
+	_, err = tx.UpdateBySql("LOCK TABLE task_dependencies IN SHARE ROW EXCLUSIVE MODE;").Exec()
+	if err != nil {
+		return err
+	}
+
+	var cycle bool
+
+	err = tx.SelectBySql(cycleQuery, blockerID, task.ID).LoadOne(&cycle)
+	if err != nil {
+		return err
+	}
+
+	if cycle {
+		return ErrTaskDependencyCycle
+	}
+
+	err = insertTaskDependency(tx, task.ID, blockerID)
+	if err != nil {
+		return err
+	}
+
+	// Create exchange message in a queue
+	dependencyAdded := &events.TaskDependencyAddedV1{
+		TaskID:    task.ID,
+		BlockerID: blockerID,
+	}
+
+	err = insertOutboxEvent(tx, dependencyAdded, task.AuthorID, task.AssigneeID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) RemoveTaskDependency(task *Task, blockerID uuid.UUID) error {
+	query := `
+DELETE FROM task_dependencies
+WHERE task_id = ? AND blocker_id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.DeleteBySql(query, task.ID, blockerID).Exec()
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return dbr.ErrNotFound
+	}
+
+	// Create exchange message in a queue
+	dependencyRemoved := &events.TaskDependencyRemovedV1{
+		TaskID:    task.ID,
+		BlockerID: blockerID,
+	}
+
+	err = insertOutboxEvent(tx, dependencyRemoved, task.AuthorID, task.AssigneeID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) GetTaskHistory(taskID uuid.UUID) (records []*TaskHistoryRecord, err error) {
+	query := `
+SELECT *
+FROM task_history
+WHERE task_id = ?
+ORDER BY created_at;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	records = make([]*TaskHistoryRecord, 0)
+
+	_, err = tx.SelectBySql(query, taskID).Load(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// insertTaskHistory snapshots the current task state into the history table,
+// so it must be called within the same transaction right after the change
+func insertTaskHistory(tx *dbr.Tx, taskID, actorID uuid.UUID, action TaskAction, reason string) error {
+	query := `
+INSERT INTO task_history(task_id, actor_id, action, status, assignee_id, reason)
+SELECT id, ?, ?, status, assignee_id, ?
+FROM tasks
+WHERE id = ?;
+`
+
+	_, err := tx.InsertBySql(
+		query,
+		nullUUID(actorID),
+		action,
+		reason,
+		taskID,
+	).Exec()
+
+	return err
+}
+
+// insertOutboxEvent stores the event in the transaction of the change it tells about,
+// along with the users whose streams the event is pushed to. The events stored by one
+// transaction share the correlation ID, it's kept in a setting local to the transaction,
+// so a subtask completion and the parent completions it causes are correlated
+func insertOutboxEvent(tx *dbr.Tx, data events.Data, recipients ...uuid.UUID) error {
+	query := `
+WITH correlation AS (
+    SELECT set_config(
+        'outbox.correlation_id',
+        COALESCE(NULLIF(current_setting('outbox.correlation_id', true), ''), ?),
+        true
+    ) AS id
+)
+INSERT INTO outbox(event_type, event_version, payload, recipients, correlation_id)
+SELECT ?, ?, ?, ?, id::UUID
+FROM correlation;
+`
+
+	err := data.Validate()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	ids := make(pq.StringArray, 0, len(recipients))
+	for _, id := range uniqueIDs(recipients) {
+		if id != uuid.Nil {
+			ids = append(ids, id.String())
+		}
+	}
+
+	// The payload goes as a string, a byte slice would be interpolated as bytea
+	_, err = tx.InsertBySql(
+		query,
+		uuid.New().String(),
+		data.EventName(),
+		data.EventVersion(),
+		string(payload),
+		ids,
+	).Exec()
+
+	return err
+}
+
+func (s *Storage) GetUsersByUsernames(usernames []string) (users []*User, err error) {
+	users = make([]*User, 0)
+	if len(usernames) == 0 {
+		return users, nil
+	}
+
+	query := `
+SELECT *
+FROM users
+WHERE username IN ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.SelectBySql(query, usernames).Load(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *Storage) CreateComment(task *Task, comment *Comment) error {
+	query := `
+INSERT INTO comments(task_id, parent_id, author_id, text)
+VALUES (?, ?, ?, ?)
+RETURNING id, created_at, updated_at;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.InsertBySql(
+		query,
+		comment.TaskID,
+		comment.ParentID,
+		comment.AuthorID,
+		comment.Text,
+	).Load(comment)
+	if err != nil {
+		return err
+	}
+
+	err = insertCommentMentions(tx, comment.ID, comment.Mentions)
+	if err != nil {
+		return err
+	}
+
+	// Create exchange message in a queue
+	commentAdded := &events.TaskCommentAddedV1{
+		TaskID:       task.ID,
+		CommentID:    comment.ID,
+		ParentID:     comment.ParentID,
+		AuthorID:     comment.AuthorID,
+		Text:         comment.Text,
+		MentionedIDs: comment.Mentions,
+	}
+
+	recipients := append([]uuid.UUID{task.AuthorID, task.AssigneeID}, comment.Mentions...)
+
+	err = insertOutboxEvent(tx, commentAdded, recipients...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) GetCommentByID(id uuid.UUID) (comment *Comment, err error) {
+	query := `
+SELECT *
+FROM comments
+WHERE id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.SelectBySql(query, id).LoadOne(&comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (s *Storage) GetCommentsByTaskID(taskID uuid.UUID) (comments []*Comment, err error) {
+	query := `
+SELECT *
+FROM comments
+WHERE task_id = ?
+ORDER BY created_at;
+`
+
+	mentionsQuery := `
+SELECT m.*
+FROM comment_mentions m
+JOIN comments c ON c.id = m.comment_id
+WHERE c.task_id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	comments = make([]*Comment, 0)
+
+	_, err = tx.SelectBySql(query, taskID).Load(&comments)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := make([]*CommentMention, 0)
+
+	_, err = tx.SelectBySql(mentionsQuery, taskID).Load(&mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	byComment := make(map[uuid.UUID][]uuid.UUID, len(comments))
+	for _, mention := range mentions {
+		byComment[mention.CommentID] = append(byComment[mention.CommentID], mention.UserID)
+	}
+
+	for _, comment := range comments {
+		comment.Mentions = byComment[comment.ID]
+	}
+
+	return comments, nil
+}
+
+func (s *Storage) UpdateComment(comment *Comment) error {
+	query := `
+UPDATE comments
+SET text = ?, updated_at = now()
+WHERE id = ?;
+`
+
+	deleteMentionsQuery := `
+DELETE FROM comment_mentions
+WHERE comment_id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.UpdateBySql(
+		query,
+		comment.Text,
+		comment.ID,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.DeleteBySql(deleteMentionsQuery, comment.ID).Exec()
+	if err != nil {
+		return err
+	}
+
+	err = insertCommentMentions(tx, comment.ID, comment.Mentions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteComment only blanks the comment out, so the replies keep their place in the thread
+func (s *Storage) DeleteComment(commentID uuid.UUID) error {
+	query := `
+UPDATE comments
+SET text = '', deleted_at = now(), updated_at = now()
+WHERE id = ?;
+`
+
+	deleteMentionsQuery := `
+DELETE FROM comment_mentions
+WHERE comment_id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.UpdateBySql(query, commentID).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.DeleteBySql(deleteMentionsQuery, commentID).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertCommentMentions(tx *dbr.Tx, commentID uuid.UUID, userIDs []uuid.UUID) error {
+	query := `
+INSERT INTO comment_mentions(comment_id, user_id)
+VALUES (?, ?)
+ON CONFLICT DO NOTHING;
+`
+
+	for _, userID := range userIDs {
+		_, err := tx.InsertBySql(query, commentID, userID).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) CreateAttachment(attachment *Attachment) error {
+	query := `
+INSERT INTO task_attachments(id, task_id, uploader_id, filename, content_type, size, checksum, storage_key)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, created_at;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.InsertBySql(
+		query,
+		attachment.ID,
+		attachment.TaskID,
+		attachment.UploaderID,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.Checksum,
+		attachment.StorageKey,
+	).Load(attachment)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) GetAttachment(taskID, id uuid.UUID) (attachment *Attachment, err error) {
+	query := `
+SELECT *
+FROM task_attachments
+WHERE task_id = ? AND id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.SelectBySql(query, taskID, id).LoadOne(&attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func (s *Storage) GetAttachmentsByTaskID(taskID uuid.UUID) (attachments []*Attachment, err error) {
+	query := `
+SELECT *
+FROM task_attachments
+WHERE task_id = ?
+ORDER BY created_at;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	attachments = make([]*Attachment, 0)
+
+	_, err = tx.SelectBySql(query, taskID).Load(&attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachments, tx.Commit()
+}
+
+func (s *Storage) DeleteAttachment(id uuid.UUID) error {
+	query := `
+DELETE FROM task_attachments
+WHERE id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.DeleteBySql(query, id).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReserveIdempotencyKey stores a new key for the request. If there is a live key
+// with the same value already, it's returned instead and nothing is stored. A key
+// reserved longer than the lease ago without a response is taken over
+func (s *Storage) ReserveIdempotencyKey(key *IdempotencyKey, lease time.Duration) (stored *IdempotencyKey, err error) {
+	query := `
+INSERT INTO idempotency_keys(user_id, key, request_hash, expires_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (user_id, key) DO UPDATE
+SET created_at = now(),
+    expires_at = EXCLUDED.expires_at,
+    request_hash = EXCLUDED.request_hash,
+    status_code = NULL,
+    content_type = '',
+    response = NULL
+WHERE idempotency_keys.expires_at < now()
+   OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < now() - make_interval(secs => ?))
+RETURNING *;
+`
+
+	selectQuery := `
+SELECT *
+FROM idempotency_keys
+WHERE user_id = ? AND key = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	count, err := tx.SelectBySql(
+		query,
+		key.UserID,
+		key.Key,
+		key.RequestHash,
+		key.ExpiresAt,
+		lease.Seconds(),
+	).Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		err = tx.SelectBySql(selectQuery, key.UserID, key.Key).LoadOne(&stored)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, tx.Commit()
+}
+
+func (s *Storage) SaveIdempotentResponse(key *IdempotencyKey) error {
+	query := `
+UPDATE idempotency_keys
+SET status_code = ?, content_type = ?, response = ?
+WHERE user_id = ? AND key = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.UpdateBySql(
+		query,
+		key.StatusCode,
+		key.ContentType,
+		key.Response,
+		key.UserID,
+		key.Key,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) DeleteIdempotencyKey(userID uuid.UUID, key string) error {
+	query := `
+DELETE FROM idempotency_keys
+WHERE user_id = ? AND key = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.DeleteBySql(query, userID, key).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) PruneIdempotencyKeys() (int64, error) {
+	query := `
+DELETE FROM idempotency_keys
+WHERE expires_at < now();
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.DeleteBySql(query).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func (s *Storage) GetOutboxEvent(id int64) (event *OutboxEvent, err error) {
+	query := `
+SELECT *
+FROM outbox
+WHERE id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.SelectBySql(query, id).LoadOne(&event)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// RelayOutboxEvents hands the pending events to publish. The batch is claimed for the lease
+// in a short transaction, so the relays of the other replicas skip it and no transaction stays
+// open while the events are published. The events go out by ID, yet a failed event is retried
+// after the backoff while the later ones are not held back, so the consumers can't rely on the
+// order. The first failed event stops the batch, the rest of the batch is released right away.
+// An event may be published twice if the lease runs out, the consumers deduplicate it by its ID
+func (s *Storage) RelayOutboxEvents(
+	limit uint64,
+	lease time.Duration,
+	backoff func(attempts int) time.Duration,
+	publish func(*OutboxEvent) error,
+) (sent int, err error) {
+	claimQuery := `
+UPDATE outbox
+SET next_attempt_at = now() + make_interval(secs => ?)
+WHERE id IN (
+    SELECT id
+    FROM outbox
+    WHERE sent_at IS NULL AND next_attempt_at <= now()
+    ORDER BY id
+    LIMIT ?
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+`
+
+	sentQuery := `
+UPDATE outbox
+SET sent_at = now(), attempts = attempts + 1, last_error = ''
+WHERE id = ?;
+`
+
+	failedQuery := `
+UPDATE outbox
+SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?
+WHERE id = ?;
+`
+
+	releaseQuery := `
+UPDATE outbox
+SET next_attempt_at = now()
+WHERE id IN ? AND sent_at IS NULL;
+`
+
+	events, err := s.claimOutboxEvents(claimQuery, lease, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		publishErr := publish(event)
+		if publishErr != nil {
+			log.Printf("outbox event %d failed to publish: %s\n", event.ID, publishErr.Error())
+
+			nextAttemptAt := time.Now().Add(backoff(event.Attempts + 1))
+
+			_, err = s.sess.UpdateBySql(failedQuery, nextAttemptAt, publishErr.Error(), event.ID).Exec()
+			if err != nil {
+				return sent, err
+			}
+
+			rest := make([]int64, 0, len(events)-i-1)
+			for _, unsent := range events[i+1:] {
+				rest = append(rest, unsent.ID)
+			}
+
+			if len(rest) > 0 {
+				_, err = s.sess.UpdateBySql(releaseQuery, rest).Exec()
+			}
+
+			return sent, err
+		}
+
+		_, err = s.sess.UpdateBySql(sentQuery, event.ID).Exec()
+		if err != nil {
+			return sent, err
+		}
+
+		sent++
+	}
+
+	return sent, nil
+}
+
+func (s *Storage) claimOutboxEvents(query string, lease time.Duration, limit uint64) ([]*OutboxEvent, error) {
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	events := make([]*OutboxEvent, 0)
+
+	_, err = tx.SelectBySql(query, lease.Seconds(), limit).Load(&events)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING keeps no order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, tx.Commit()
+}
+
+// PruneOutboxEvents deletes the events which have been sent before the time
+func (s *Storage) PruneOutboxEvents(before time.Time) (int64, error) {
+	query := `
+DELETE FROM outbox
+WHERE sent_at < ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.DeleteBySql(query, before).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func (s *Storage) CreateTemplate(template *TaskTemplate) error {
+	query := `
+INSERT INTO task_templates(author_id, title, description, labels, priority, schedule, due_in, next_run_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, created_at, updated_at, active;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	err = tx.InsertBySql(
+		query,
+		template.AuthorID,
+		template.Title,
+		template.Description,
+		template.Labels,
+		template.Priority,
+		template.Schedule,
+		template.DueIn,
+		template.NextRunAt,
+	).Load(template)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) GetTemplates() (templates []*TaskTemplate, err error) {
+	query := `
+SELECT *
+FROM task_templates
+WHERE active
+ORDER BY next_run_at;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	templates = make([]*TaskTemplate, 0)
+
+	_, err = tx.SelectBySql(query).Load(&templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, tx.Commit()
+}
+
+// DeactivateTemplate stops the template from being scheduled, the tasks
+// created from it are kept as they are
+func (s *Storage) DeactivateTemplate(id uuid.UUID) error {
+	query := `
+UPDATE task_templates
+SET active = false, updated_at = now()
+WHERE id = ? AND active;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	res, err := tx.UpdateBySql(query, id).Exec()
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return dbr.ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// ClaimDueTemplates returns the active templates which are due at the moment
+// and moves their next run according to the schedule. The claim is guarded by
+// a transaction-level advisory lock, so when several replicas are running only
+// one of them gets the templates, the rest get nothing. Runs missed while the
+// service was down are collapsed into a single one
+func (s *Storage) ClaimDueTemplates(now time.Time) (templates []*TaskTemplate, err error) {
+	lockQuery := `
+SELECT pg_try_advisory_xact_lock(?);
+`
+
+	selectQuery := `
+SELECT *
+FROM task_templates
+WHERE active AND next_run_at <= ?
+ORDER BY next_run_at
+FOR UPDATE;
+`
+
+	updateQuery := `
+UPDATE task_templates
+SET next_run_at = ?, last_run_at = ?, active = ?, updated_at = now()
+WHERE id = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	templates = make([]*TaskTemplate, 0)
+
+	var locked bool
+
+	err = tx.SelectBySql(lockQuery, advisoryLockKey(schedulerLockName)).LoadOne(&locked)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another replica is busy with the templates
+	if !locked {
+		return templates, nil
+	}
+
+	_, err = tx.SelectBySql(selectQuery, now).Load(&templates)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, template := range templates {
+		next := now
+		active := false
+
+		schedule, parseErr := ParseCron(template.Schedule)
+		if parseErr == nil {
+			next = schedule.Next(now)
+			active = !next.IsZero()
+		}
+
+		// The schedule never fires again, so the template is retired
+		if !active {
+			log.Printf("Deactivating task template %s with schedule %q\n", template.ID, template.Schedule)
+			next = template.NextRunAt
+		}
+
+		_, err = tx.UpdateBySql(updateQuery, next, now, active, template.ID).Exec()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templates, tx.Commit()
+}
+
+// ReleaseTemplateRun hands the claimed run of the template back, so the run is retried
+// on the next claim. The run is released only if the template hasn't been claimed since
+func (s *Storage) ReleaseTemplateRun(template *TaskTemplate, claimedAt time.Time) error {
+	query := `
+UPDATE task_templates
+SET next_run_at = ?, active = true, updated_at = now()
+WHERE id = ? AND last_run_at = ?;
+`
+
+	tx, err := s.sess.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.UpdateBySql(query, template.NextRunAt, template.ID, claimedAt).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertTask(tx *dbr.Tx, task *Task) error {
+	query := `
+INSERT INTO tasks(title, description, status, author_id, assignee_id, due_at, labels, priority, parent_id, auto_complete)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, version;
+`
+
+	related := task.BlockedBy
+	if task.ParentID.Valid {
+		related = append([]uuid.UUID{task.ParentID.UUID}, related...)
+	}
+
+	err := checkTasksExist(tx, related)
+	if err != nil {
+		return err
+	}
+
+	err = tx.InsertBySql(
+		query,
+		task.Title,
+		task.Description,
+		task.Status,
+		task.AuthorID,
+		nullUUID(task.AssigneeID),
+		task.DueAt,
+		task.Labels,
+		task.Priority,
+		task.ParentID,
+		task.AutoComplete,
+	).Load(task)
+	if err != nil {
+		return err
+	}
+
+	// A brand new task can't be a blocker of anything, so there are no cycles to check
+	for _, blockerID := range task.BlockedBy {
+		err = insertTaskDependency(tx, task.ID, blockerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertTaskHistory(tx, task.ID, task.AuthorID, createdAction, "")
+	if err != nil {
+		return err
+	}
+
+	// Create exchange message in a queue
+	taskCreated := &events.TaskCreatedV1{
+		TaskID:      task.ID,
+		ParentID:    task.ParentID,
+		BlockedBy:   task.BlockedBy,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      string(task.Status),
+		AssigneeID:  task.AssigneeID,
+		Labels:      task.Labels,
+		Priority:    string(task.Priority),
+	}
+
+	return insertOutboxEvent(tx, taskCreated, task.AuthorID, task.AssigneeID)
+}
+
+// applyTaskTransition moves the task to another status, completing the
+// auto-complete parents if the task is completed
+func applyTaskTransition(tx *dbr.Tx, transition *TaskTransition) (prev *Task, parents []*Task, err error) {
+	prev, err = updateTaskStatus(tx, transition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if transition.Action == completedAction {
+		parents, err = autoCompleteParents(tx, prev, transition.ActorID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = insertTaskTransitionEvents(tx, transition, prev, parents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return prev, parents, nil
+}
+
+// insertTaskTransitionEvents stores the events of a status change, the auto-completed parents included
+func insertTaskTransitionEvents(tx *dbr.Tx, transition *TaskTransition, prev *Task, parents []*Task) error {
+	// Create exchange messages in a queue
+	switch transition.Action {
+	case completedAction:
+		for _, completed := range append([]*Task{prev}, parents...) {
+			taskCompleted := &events.TaskCompletedV1{
+				TaskID:     completed.ID,
+				AssigneeID: completed.AssigneeID,
+			}
+
+			recipients := []uuid.UUID{completed.AuthorID, completed.AssigneeID}
+
+			err := insertOutboxEvent(tx, taskCompleted, recipients...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case cancelledAction:
+		taskCancelled := &events.TaskCancelledV1{
+			TaskID:         prev.ID,
+			Reason:         transition.Reason,
+			PrevStatus:     string(prev.Status),
+			PrevAssigneeID: prev.AssigneeID,
+		}
+
+		return insertOutboxEvent(tx, taskCancelled, prev.AuthorID, prev.AssigneeID)
+	case reopenedAction:
+		taskReopened := &events.TaskReopenedV1{
+			TaskID:         prev.ID,
+			Reason:         transition.Reason,
+			PrevStatus:     string(prev.Status),
+			PrevAssigneeID: prev.AssigneeID,
+			AssigneeID:     prev.AssigneeID,
+		}
+
+		return insertOutboxEvent(tx, taskReopened, prev.AuthorID, prev.AssigneeID)
+	default:
+		return nil
+	}
+}
+
+// lockCapacity locks the worker and makes sure they've got room for one more open task apart from
+// the given one, ErrWorkerAtCapacity is returned otherwise. The worker stays locked until the end
+// of the transaction, so the concurrent assignments to the worker are counted one after another
+func lockCapacity(tx *dbr.Tx, assigneeID, taskID uuid.UUID, maxOpenTasks int64) error {
+	lockQuery := `
+SELECT max_open_tasks
+FROM users
+WHERE id = ?
+FOR UPDATE;
+`
+
+	countQuery := `
+SELECT count(*)
+FROM tasks
+WHERE assignee_id = ? AND status = ? AND id <> ?;
+`
+
+	if assigneeID == uuid.Nil {
+		return nil
+	}
+
+	var limit dbr.NullInt64
+
+	err := tx.SelectBySql(lockQuery, assigneeID).LoadOne(&limit)
+	if err != nil {
+		return err
+	}
+
+	// The limit of the worker overrides the global one
+	if !limit.Valid {
+		if maxOpenTasks <= 0 {
+			return nil
+		}
+
+		limit = dbr.NewNullInt64(maxOpenTasks)
+	}
+
+	var openTasks int64
+
+	err = tx.SelectBySql(countQuery, assigneeID, createdStatus, taskID).LoadOne(&openTasks)
+	if err != nil {
+		return err
+	}
+
+	if openTasks >= limit.Int64 {
+		return fmt.Errorf("%w: %s", ErrWorkerAtCapacity, assigneeID)
+	}
+
+	return nil
+}
+
+// updateTaskAssignee hands the task over to another worker. An open task taken away
+// from its assignee becomes unassigned, and the other way round
+func updateTaskAssignee(tx *dbr.Tx, task *Task, actorID uuid.UUID) error {
+	// The self-join gives the row as it was before the update
+	query := `
+UPDATE tasks t
+SET assignee_id = ?, status = ?, version = t.version + 1, updated_at = now()
+FROM tasks prev
+WHERE t.id = prev.id AND t.id = ? AND t.version = ?
+RETURNING t.version, prev.assignee_id AS prev_assignee_id;
+`
+
+	action := assignedAction
+
+	switch {
+	case task.AssigneeID == uuid.Nil && task.Status == createdStatus:
+		task.Status = unassignedStatus
+	case task.AssigneeID != uuid.Nil && task.Status == unassignedStatus:
+		task.Status = createdStatus
+	}
+
+	if task.AssigneeID == uuid.Nil {
+		action = unassignedAction
+	}
+
+	var updated struct {
+		Version        int64
+		PrevAssigneeID uuid.UUID
+	}
+
+	count, err := tx.SelectBySql(
+		query,
+		nullUUID(task.AssigneeID),
+		task.Status,
+		task.ID,
+		task.Version,
+	).Load(&updated)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrTaskVersionConflict
+	}
+
+	task.Version = updated.Version
+
+	err = insertTaskHistory(tx, task.ID, actorID, action, "")
+	if err != nil {
+		return err
+	}
+
+	// Create exchange message in a queue
+	if task.AssigneeID == uuid.Nil {
+		taskUnassigned := &events.TaskUnassignedV1{
+			TaskID:         task.ID,
+			PrevAssigneeID: updated.PrevAssigneeID,
+		}
+
+		return insertOutboxEvent(tx, taskUnassigned, task.AuthorID, updated.PrevAssigneeID)
+	}
+
+	taskAssigned := &events.TaskAssignedV1{
+		TaskID:     task.ID,
+		AssigneeID: task.AssigneeID,
+	}
+
+	return insertOutboxEvent(tx, taskAssigned, updated.PrevAssigneeID, task.AssigneeID)
+}
+
+func updateTaskLabels(tx *dbr.Tx, task *Task, actorID uuid.UUID) error {
+	// The self-join gives the row as it was before the update
+	query := `
+UPDATE tasks t
+SET labels = ?, version = t.version + 1, updated_at = now()
+FROM tasks prev
+WHERE t.id = prev.id AND t.id = ? AND t.version = ?
+RETURNING t.version, prev.labels AS prev_labels;
+`
+
+	var updated struct {
+		Version    int64
+		PrevLabels pq.StringArray
+	}
+
+	count, err := tx.SelectBySql(
+		query,
+		task.Labels,
+		task.ID,
+		task.Version,
+	).Load(&updated)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrTaskVersionConflict
+	}
+
+	task.Version = updated.Version
+
+	err = insertTaskHistory(tx, task.ID, actorID, labelledAction, "")
+	if err != nil {
+		return err
+	}
+
+	// Create exchange message in a queue
+	taskLabelsUpdated := &events.TaskLabelsUpdatedV1{
+		TaskID:     task.ID,
+		Labels:     task.Labels,
+		PrevLabels: updated.PrevLabels,
+	}
+
+	return insertOutboxEvent(tx, taskLabelsUpdated, task.AuthorID, task.AssigneeID)
+}
+
+// applyTaskBulkItem changes a single task of the bulk. The changes are conditional
+// on the task version the item was checked against, so the permission checks made
+// before are still valid for the task
+func applyTaskBulkItem(tx *dbr.Tx, bulk *TaskBulk, item *TaskBulkItem) (err error) {
+	prev := *item.Task
+	item.Prev = &prev
+
+	switch bulk.Operation {
+	case completeBulkOperation, cancelBulkOperation:
+		action := completedAction
+		if bulk.Operation == cancelBulkOperation {
+			action = cancelledAction
+		}
+
+		item.Prev, item.Parents, err = applyTaskTransition(tx, &TaskTransition{
+			TaskID:  item.Task.ID,
+			ActorID: bulk.ActorID,
+			Action:  action,
+			Reason:  bulk.Reason,
+			Version: item.Task.Version,
+		})
+
+		return err
+	case reassignBulkOperation:
+		item.Task.AssigneeID = bulk.AssigneeID
+
+		return updateTaskAssignee(tx, item.Task, bulk.ActorID)
+	case setLabelsBulkOperation:
+		item.Task.Labels = bulk.Labels
+
+		return updateTaskLabels(tx, item.Task, bulk.ActorID)
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidBulkOperation, bulk.Operation)
+	}
+}
+
+func updateTaskStatus(tx *dbr.Tx, transition *TaskTransition) (prev *Task, err error) {
+	lockQuery := `
+SELECT *
+FROM tasks
+WHERE id = ?
+FOR UPDATE;
+`
+
+	blockersQuery := `
+SELECT count(*)
+FROM task_dependencies d
+JOIN tasks t ON t.id = d.blocker_id
+WHERE d.task_id = ? AND t.status IN ?;
+`
+
+	// A reopened task is flagged overdue anew if it's still past its due date
+	query := `
+UPDATE tasks
+SET status = ?,
+    overdue_at = CASE WHEN ? THEN NULL ELSE overdue_at END,
+    version = version + 1,
+    updated_at = now()
+WHERE id = ? AND version = ?;
+`
+
+	err = tx.SelectBySql(lockQuery, transition.TaskID).LoadOne(&prev)
+	if err != nil {
+		return nil, err
+	}
+
+	if transition.Version != 0 && transition.Version != prev.Version {
+		return nil, ErrTaskVersionConflict
+	}
+
+	from, to := transitionStatuses(transition.Action)
+	if !containsStatus(from, prev.Status) {
+		return nil, ErrTaskStatusConflict
+	}
+
+	// A reopened task no one has been assigned to goes back to waiting for a worker
+	if to == createdStatus && prev.AssigneeID == uuid.Nil {
+		to = unassignedStatus
+	}
+
+	if transition.Action == completedAction {
+		var blockers int
+
+		err = tx.SelectBySql(blockersQuery, transition.TaskID, openStatuses()).LoadOne(&blockers)
+		if err != nil {
+			return nil, err
+		}
+
+		if blockers > 0 {
+			return nil, ErrTaskBlocked
+		}
+	}
+
+	res, err := tx.UpdateBySql(
+		query,
+		to,
+		transition.Action == reopenedAction,
+		transition.TaskID,
+		prev.Version,
+	).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if affected == 0 {
+		return nil, ErrTaskVersionConflict
+	}
+
+	err = insertTaskHistory(tx, transition.TaskID, transition.ActorID, transition.Action, transition.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return prev, nil
+}
+
+// autoCompleteParents walks up from the just completed task, completing every
+// auto-complete parent which has no open subtasks and blockers left
+func autoCompleteParents(tx *dbr.Tx, task *Task, actorID uuid.UUID) (parents []*Task, err error) {
+	parentQuery := `
+SELECT *
+FROM tasks
+WHERE id = ?;
+`
+
+	subtasksQuery := `
+SELECT count(*)
+FROM tasks
+WHERE parent_id = ? AND status IN ?;
+`
+
+	parents = make([]*Task, 0)
+
+	for task.ParentID.Valid {
+		var parent *Task
+
+		err = tx.SelectBySql(parentQuery, task.ParentID.UUID).LoadOne(&parent)
+		if err != nil {
+			return nil, err
+		}
+
+		if !parent.AutoComplete || parent.Status != createdStatus {
+			break
+		}
+
+		var subtasks int
+
+		err = tx.SelectBySql(subtasksQuery, parent.ID, openStatuses()).LoadOne(&subtasks)
+		if err != nil {
+			return nil, err
+		}
+
+		if subtasks > 0 {
+			break
+		}
+
+		task, err = updateTaskStatus(tx, &TaskTransition{
+			TaskID:  parent.ID,
+			ActorID: actorID,
+			Action:  completedAction,
+			Reason:  autoCompleteReason,
+		})
+		if errors.Is(err, ErrTaskBlocked) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parents = append(parents, task)
+	}
+
+	return parents, nil
+}
+
+func insertTaskDependency(tx *dbr.Tx, taskID, blockerID uuid.UUID) error {
+	query := `
+INSERT INTO task_dependencies(task_id, blocker_id)
+VALUES (?, ?)
+ON CONFLICT DO NOTHING;
+`
+
+	_, err := tx.InsertBySql(query, taskID, blockerID).Exec()
+
+	return err
+}
+
+// checkTasksExist returns ErrRelatedTaskNotFound if any of the tasks is missing
+func checkTasksExist(tx *dbr.Tx, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+SELECT count(DISTINCT id)
+FROM tasks
+WHERE id IN ?;
+`
+
+	unique := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		unique[id] = struct{}{}
+	}
+
+	var count int
+
+	err := tx.SelectBySql(query, ids).LoadOne(&count)
+	if err != nil {
+		return err
+	}
+
+	if count != len(unique) {
+		return ErrRelatedTaskNotFound
+	}
+
+	return nil
+}
+
+// loadTaskRelations fills in blockers and subtasks of the tasks
+func loadTaskRelations(tx *dbr.Tx, tasks []*Task) error {
+	dependenciesQuery := `
+SELECT task_id, blocker_id
+FROM task_dependencies
+WHERE task_id IN ?;
+`
+
+	subtasksQuery := `
+SELECT id, parent_id
+FROM tasks
+WHERE parent_id IN ?
+ORDER BY created_at;
+`
+
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(tasks))
+	byID := make(map[uuid.UUID]*Task, len(tasks))
+	for _, task := range tasks {
+		task.BlockedBy = make([]uuid.UUID, 0)
+		task.SubtaskIDs = make([]uuid.UUID, 0)
+
+		ids = append(ids, task.ID)
+		byID[task.ID] = task
+	}
+
+	dependencies := make([]*TaskDependency, 0)
+
+	_, err := tx.SelectBySql(dependenciesQuery, ids).Load(&dependencies)
+	if err != nil {
+		return err
+	}
+
+	for _, dependency := range dependencies {
+		task := byID[dependency.TaskID]
+		task.BlockedBy = append(task.BlockedBy, dependency.BlockerID)
+	}
+
+	subtasks := make([]*Task, 0)
+
+	_, err = tx.SelectBySql(subtasksQuery, ids).Load(&subtasks)
+	if err != nil {
+		return err
+	}
+
+	for _, subtask := range subtasks {
+		task := byID[subtask.ParentID.UUID]
+		task.SubtaskIDs = append(task.SubtaskIDs, subtask.ID)
+	}
+
+	return nil
+}
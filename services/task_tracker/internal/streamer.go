@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func NewStreamer(config *Config, storage *Storage, hub *Hub) *Streamer {
+	return &Streamer{
+		config:  config,
+		storage: storage,
+		hub:     hub,
+	}
+}
+
+// Process pushes every committed outbox event to the streams of its recipients connected
+// to this replica. It runs on each replica, so a user gets the event whichever replica
+// the stream is connected to, and whether the event bus takes the event at once or not
+func (s *Streamer) Process(ctx context.Context) error {
+	listener := pq.NewListener(s.config.DB.uri(), time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	err := listener.Listen(streamChannel)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// The listener reconnected, the events committed meanwhile are gone for the streams.
+			// The streams are best effort, the clients refetch the tasks when they reconnect
+			if notification == nil {
+				log.Println("task_tracker.Streamer: listener reconnected, stream events may be missed")
+				continue
+			}
+
+			err = s.stream(notification.Extra)
+			if err != nil {
+				log.Printf("task_tracker.stream error: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// stream pushes the outbox event of the notification to the recipients' streams
+func (s *Streamer) stream(payload string) error {
+	id, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	event, err := s.storage.GetOutboxEvent(id)
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]uuid.UUID, 0, len(event.Recipients))
+	for _, recipient := range event.Recipients {
+		userID, err := uuid.Parse(recipient)
+		if err != nil {
+			continue
+		}
+
+		recipients = append(recipients, userID)
+	}
+
+	s.hub.Broadcast(event.EventType, json.RawMessage(event.Payload), recipients...)
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ParseTaskImport reads the rows to import in the format the Content-Type header
+// names. Unknown CSV columns and JSON fields are skipped, so an export may be
+// imported back as it is. The errors of a single CSV row are kept in the row,
+// the errors of the file as a whole are returned
+func ParseTaskImport(w http.ResponseWriter, r *http.Request, config *TransferConfig) ([]*TaskImportRow, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrImportMediaType
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.ImportMaxSize)
+
+	var rows []*TaskImportRow
+
+	switch mediaType {
+	case jsonContentType:
+		rows, err = parseJSONImport(r.Body)
+	case csvContentType:
+		rows, err = parseCSVImport(r.Body, config.ImportMaxRows)
+	default:
+		return nil, ErrImportMediaType
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, ErrImportTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no rows", ErrInvalidImport)
+	}
+
+	if len(rows) > config.ImportMaxRows {
+		return nil, fmt.Errorf("%w: more than %d rows", ErrImportTooLarge, config.ImportMaxRows)
+	}
+
+	return rows, nil
+}
+
+func parseJSONImport(body io.Reader) ([]*TaskImportRow, error) {
+	rows := make([]*TaskImportRow, 0)
+
+	err := json.NewDecoder(body).Decode(&rows)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+	}
+
+	return rows, nil
+}
+
+func parseCSVImport(body io.Reader, maxRows int) ([]*TaskImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: no header", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, csvImportError(err)
+	}
+
+	for i, column := range header {
+		// Spreadsheet editors like to start the files with a byte order mark
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+	}
+
+	rows := make([]*TaskImportRow, 0)
+
+	// One extra row is read to tell the file is too long
+	for len(rows) <= maxRows {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, csvImportError(err)
+		}
+
+		rows = append(rows, parseCSVImportRow(header, record))
+	}
+
+	return rows, nil
+}
+
+func parseCSVImportRow(header, record []string) *TaskImportRow {
+	row := new(TaskImportRow)
+
+	for i, value := range record {
+		switch header[i] {
+		case "title":
+			row.Title = value
+		case "description":
+			row.Description = value
+		case "author":
+			row.Author = strings.TrimSpace(value)
+		case "assignee":
+			row.Assignee = strings.TrimSpace(value)
+		case "labels":
+			row.Labels = strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ';'
+			})
+		case "priority":
+			row.Priority = TaskPriority(strings.TrimSpace(value))
+		case "due_at":
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			dueAt, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				row.err = fmt.Errorf("%w: due_at is not an RFC 3339 time", ErrInvalidImport)
+				continue
+			}
+
+			row.DueAt = &dueAt
+		}
+	}
+
+	return row
+}
+
+func csvImportError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+}
+
+func NewTaskExportWriter(w http.ResponseWriter, format string) *TaskExportWriter {
+	writer := &TaskExportWriter{
+		w:      w,
+		format: format,
+	}
+
+	writer.flusher, _ = w.(http.Flusher)
+
+	if format == csvTransferFormat {
+		writer.csv = csv.NewWriter(w)
+	}
+
+	return writer
+}
+
+// Write sends a single task out, the rows are flushed to the client in batches
+func (e *TaskExportWriter) Write(record *TaskExportRecord) error {
+	var err error
+
+	if e.rows == 0 {
+		err = e.begin()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch e.format {
+	case csvTransferFormat:
+		var dueAt string
+		if record.DueAt != nil {
+			dueAt = record.DueAt.UTC().Format(time.RFC3339)
+		}
+
+		err = e.csv.Write([]string{
+			record.ID.String(),
+			record.CreatedAt.UTC().Format(time.RFC3339),
+			record.Title,
+			record.Description,
+			string(record.Status),
+			record.Author,
+			record.Assignee.String,
+			strings.Join(record.Labels, ","),
+			string(record.Priority),
+			dueAt,
+		})
+	default:
+		var data []byte
+
+		data, err = json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if e.rows > 0 {
+			_, err = io.WriteString(e.w, ",")
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = e.w.Write(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+
+	return nil
+}
+
+// Close finishes the export, it has to be called even if there are no tasks
+func (e *TaskExportWriter) Close() error {
+	if e.rows == 0 {
+		err := e.begin()
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.format != csvTransferFormat {
+		_, err := io.WriteString(e.w, "]")
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.flush()
+}
+
+func (e *TaskExportWriter) begin() error {
+	// The import understands every column of the export
+	if e.format == csvTransferFormat {
+		return e.csv.Write([]string{
+			"id", "created_at", "title", "description", "status",
+			"author", "assignee", "labels", "priority", "due_at",
+		})
+	}
+
+	_, err := io.WriteString(e.w, "[")
+
+	return err
+}
+
+func (e *TaskExportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+
+		err := e.csv.Error()
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+
+	return nil
+}
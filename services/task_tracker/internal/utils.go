@@ -1,18 +1,39 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gocraft/dbr/v2"
+	"github.com/google/uuid"
 )
 
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
 func BodyParser(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return BodyParserWithLimit(w, r, dst, bodyMaxSize)
+}
+
+// BodyParserWithLimit is BodyParser for the requests which are bigger than usual
+func BodyParserWithLimit(w http.ResponseWriter, r *http.Request, dst interface{}, limit int64) error {
 	if r.Header.Get("Content-Type") != "application/json" {
 		return ErrUnsupportedMediaType
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<10)
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
 
 	dec := json.NewDecoder(r.Body)
 	// Return error on any fields mismatches
@@ -26,6 +47,126 @@ func BodyParser(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	return nil
 }
 
+// ParseTaskFilter reads task listing filters from the URL query
+func ParseTaskFilter(r *http.Request) (*TaskFilter, error) {
+	query := r.URL.Query()
+	filter := new(TaskFilter)
+
+	if value := query.Get(queryParamAssigneeID); value != "" {
+		assigneeID, err := uuid.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamAssigneeID)
+		}
+
+		filter.AssigneeID = uuid.NullUUID{UUID: assigneeID, Valid: true}
+	}
+
+	for param, dst := range map[string]**time.Time{
+		queryParamDueAfter:  &filter.DueAfter,
+		queryParamDueBefore: &filter.DueBefore,
+	} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueryParam, param)
+		}
+
+		*dst = &t
+	}
+
+	filter.Labels = NormalizeTags(query[queryParamLabel])
+
+	if value := TaskPriority(query.Get(queryParamPriority)); value != "" {
+		if !validPriority(value) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamPriority)
+		}
+
+		filter.Priority = value
+	}
+
+	if value := query.Get(queryParamOverdue); value != "" {
+		overdue, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamOverdue)
+		}
+
+		filter.Overdue = &overdue
+	}
+
+	return filter, nil
+}
+
+// ParseTaskSearch reads the search text and the result limit from the URL query
+func ParseTaskSearch(r *http.Request) (text string, limit uint64, err error) {
+	query := r.URL.Query()
+
+	text = strings.TrimSpace(query.Get(queryParamQuery))
+	if text == "" {
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamQuery)
+	}
+
+	limit = taskSearchDefaultLimit
+
+	if value := query.Get(queryParamLimit); value != "" {
+		limit, err = strconv.ParseUint(value, 10, 64)
+		if err != nil || limit == 0 || limit > taskSearchMaxLimit {
+			return "", 0, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamLimit)
+		}
+	}
+
+	return text, limit, nil
+}
+
+// ParseDeadLetterLimit gets the number of dead letters to list from the request query
+func ParseDeadLetterLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get(queryParamLimit)
+	if value == "" {
+		return deadLetterDefaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > deadLetterMaxLimit {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidQueryParam, queryParamLimit)
+	}
+
+	return limit, nil
+}
+
+// FormatETag renders the task version as an entity tag
+func FormatETag(version int64) string {
+	return fmt.Sprintf("%q", strconv.FormatInt(version, 10))
+}
+
+// ParseIfMatch returns the task version expected by the If-Match header,
+// zero means the request is not conditional. If-Match compares the tags strongly,
+// so a weak tag never matches and ErrWeakIfMatch is returned for it (RFC 9110 13.1.1)
+func ParseIfMatch(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get(HeaderIfMatch))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	if strings.HasPrefix(value, "W/") {
+		return 0, fmt.Errorf("%w: %s", ErrWeakIfMatch, value)
+	}
+
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidIfMatch, value)
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidIfMatch, value)
+	}
+
+	return version, nil
+}
+
 func ExtractToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get(HeaderAuth)
 	if authHeader == "" {
@@ -40,3 +181,407 @@ func ExtractToken(r *http.Request) (string, error) {
 
 	return token, nil
 }
+
+// ExtractMentions returns unique usernames mentioned in a text as @username
+func ExtractMentions(text string) []string {
+	seen := make(map[string]struct{})
+	usernames := make([]string, 0)
+
+	for _, match := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+		username := strings.TrimRight(match[1], ".-")
+		if _, ok := seen[username]; ok || username == "" {
+			continue
+		}
+
+		seen[username] = struct{}{}
+		usernames = append(usernames, username)
+	}
+
+	return usernames
+}
+
+// transitionStatuses returns statuses a task may be in before the action
+// along with the status it ends up in
+func transitionStatuses(action TaskAction) (from []TaskStatus, to TaskStatus) {
+	switch action {
+	case completedAction:
+		return []TaskStatus{createdStatus}, completedStatus
+	case cancelledAction:
+		return []TaskStatus{createdStatus, unassignedStatus, completedStatus}, cancelledStatus
+	case reopenedAction:
+		return []TaskStatus{completedStatus, cancelledStatus}, createdStatus
+	default:
+		return nil, ""
+	}
+}
+
+// openStatuses are the statuses of the tasks which are still to be done
+func openStatuses() []TaskStatus {
+	return []TaskStatus{createdStatus, unassignedStatus}
+}
+
+// nullUUID turns the nil UUID into NULL for the nullable columns
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+// NormalizeTags lowercases and trims the tags dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+func validPriority(priority TaskPriority) bool {
+	switch priority {
+	case lowPriority, normalPriority, highPriority, criticalPriority:
+		return true
+	default:
+		return false
+	}
+}
+
+// pickAssignee randomly chooses a worker for the task. With skills matching on,
+// workers having a skill among the task labels are preferred, if there are any
+func pickAssignee(workers []*User, task *Task, matchSkills bool) *User {
+	candidates := workers
+
+	if matchSkills && len(task.Labels) > 0 {
+		matching := make([]*User, 0, len(workers))
+		for _, worker := range workers {
+			if hasAnyTag(worker.Skills, task.Labels) {
+				matching = append(matching, worker)
+			}
+		}
+
+		if len(matching) > 0 {
+			candidates = matching
+		}
+	}
+
+	//nolint:gosec // It's ok for now
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// applyCapacity works out the limit in effect for every worker
+func applyCapacity(workload []*Workload, maxOpenTasks int64) {
+	for _, load := range workload {
+		switch {
+		case load.MaxOpenTasks.Valid:
+			load.Capacity = load.MaxOpenTasks
+		case maxOpenTasks > 0:
+			load.Capacity = dbr.NewNullInt64(maxOpenTasks)
+		default:
+			load.Capacity = dbr.NullInt64{}
+		}
+	}
+}
+
+// pickAvailableAssignee chooses a worker for the task among the ones below their
+// limits and counts the task in. Nil is returned when every worker is at capacity
+func pickAvailableAssignee(workload []*Workload, task *Task, matchSkills bool) *Workload {
+	loads := make(map[uuid.UUID]*Workload, len(workload))
+	workers := make([]*User, 0, len(workload))
+
+	for _, load := range workload {
+		if load.Capacity.Valid && load.OpenTasks >= load.Capacity.Int64 {
+			continue
+		}
+
+		loads[load.UserID] = load
+		workers = append(workers, &User{
+			ID:       load.UserID,
+			Username: load.Username,
+			Role:     workerRole,
+			Skills:   load.Skills,
+		})
+	}
+
+	if len(workers) == 0 {
+		return nil
+	}
+
+	load := loads[pickAssignee(workers, task, matchSkills).ID]
+	load.OpenTasks++
+
+	return load
+}
+
+// releaseAssignee counts the task out of the workload of its current assignee
+func releaseAssignee(workload []*Workload, task *Task) {
+	if task.Status != createdStatus {
+		return
+	}
+
+	for _, load := range workload {
+		if load.UserID == task.AssigneeID && load.OpenTasks > 0 {
+			load.OpenTasks--
+			return
+		}
+	}
+}
+
+// fillCapacity marks the worker as having no room left, the workload turned out to be outdated
+func fillCapacity(workload []*Workload, userID uuid.UUID) {
+	for _, load := range workload {
+		if load.UserID == userID {
+			load.Capacity = dbr.NewNullInt64(load.OpenTasks)
+			return
+		}
+	}
+}
+
+// holdAssignee counts the task in the workload of its assignee
+func holdAssignee(workload []*Workload, task *Task) {
+	for _, load := range workload {
+		if load.UserID == task.AssigneeID {
+			load.OpenTasks++
+			return
+		}
+	}
+}
+
+// priorityRank orders the priorities from the least urgent one
+func priorityRank(priority TaskPriority) int {
+	switch priority {
+	case lowPriority:
+		return 1
+	case normalPriority:
+		return 2
+	case highPriority:
+		return 3
+	case criticalPriority:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, item := range wanted {
+			if tag == item {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsStatus(statuses []TaskStatus, status TaskStatus) bool {
+	for _, item := range statuses {
+		if item == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]struct{}, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+
+	return unique
+}
+
+// canEditTask checks whether a user is allowed to change the task structure
+func canEditTask(user *User, task *Task) bool {
+	if user.Role == adminRole || user.Role == managerRole {
+		return true
+	}
+
+	return task.AuthorID == user.ID
+}
+
+// canCompleteTask checks whether a user is allowed to close the task out
+func canCompleteTask(user *User, task *Task) bool {
+	if user.Role == adminRole || user.Role == managerRole {
+		return true
+	}
+
+	return task.AssigneeID == user.ID
+}
+
+// checkTaskBulkItem checks whether the user may apply the bulk operation to the task.
+// A task the user can't see is reported as missing, so its ID can't be probed
+func checkTaskBulkItem(user *User, bulk *TaskBulk, task *Task) error {
+	if task == nil || !canViewTask(user, task) {
+		return ErrTaskNotFound
+	}
+
+	var allowed bool
+
+	switch bulk.Operation {
+	case completeBulkOperation:
+		allowed = canCompleteTask(user, task)
+	case cancelBulkOperation, reassignBulkOperation:
+		allowed = user.Role == adminRole || user.Role == managerRole
+	case setLabelsBulkOperation:
+		allowed = canEditTask(user, task)
+	}
+
+	if !allowed {
+		return ErrTaskForbidden
+	}
+
+	// Only the open tasks are handed over, just like the reshuffle does
+	if bulk.Operation == reassignBulkOperation && !containsStatus(openStatuses(), task.Status) {
+		return ErrTaskStatusConflict
+	}
+
+	return nil
+}
+
+// canViewTask checks whether a user is allowed to look into the task details
+func canViewTask(user *User, task *Task) bool {
+	if user.Role == adminRole || user.Role == managerRole {
+		return true
+	}
+
+	return task.AuthorID == user.ID || task.AssigneeID == user.ID
+}
+
+// ReadAttachment reads the file part of a multipart form. The file is kept in
+// memory as a whole, so it's the size limit which keeps the memory usage sane.
+// The content type is sniffed from the data rather than trusted to the client
+func ReadAttachment(w http.ResponseWriter, r *http.Request, config *AttachmentConfig) (*Attachment, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxSize+attachmentFormOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAttachmentForm, err.Error())
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("%w: no %q field", ErrAttachmentForm, attachmentFormField)
+		}
+		if err != nil {
+			return nil, nil, attachmentReadError(err)
+		}
+
+		if part.FormName() != attachmentFormField {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, config.MaxSize+1))
+		if err != nil {
+			return nil, nil, attachmentReadError(err)
+		}
+
+		if int64(len(data)) > config.MaxSize {
+			return nil, nil, ErrAttachmentTooLarge
+		}
+
+		if len(data) == 0 {
+			return nil, nil, fmt.Errorf("%w: empty file", ErrAttachmentForm)
+		}
+
+		contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrAttachmentType, err.Error())
+		}
+
+		if !containsString(config.AllowedTypes, contentType) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrAttachmentType, contentType)
+		}
+
+		checksum := sha256.Sum256(data)
+
+		return &Attachment{
+			Filename:    attachmentName(part.FileName()),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Checksum:    hex.EncodeToString(checksum[:]),
+		}, data, nil
+	}
+}
+
+func attachmentReadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrAttachmentTooLarge
+	}
+
+	return fmt.Errorf("%w: %s", ErrAttachmentForm, err.Error())
+}
+
+// attachmentName cleans up the client file name, so it fits into the metadata table
+func attachmentName(name string) string {
+	// Some clients send full paths, either of Unix or Windows flavour
+	name = strings.TrimSpace(name[strings.LastIndexAny(name, `/\`)+1:])
+	if name == "" || name == "." || name == ".." {
+		return attachmentFormField
+	}
+
+	runes := []rune(name)
+	if len(runes) > attachmentNameMaxLength {
+		name = string(runes[:attachmentNameMaxLength])
+	}
+
+	return name
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail records the item error along with the status code a single task request would get
+func (r *TaskBulkResult) fail(err error) {
+	r.Status = failedBulkResult
+	r.Error = err.Error()
+
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		r.Code = http.StatusNotFound
+	case errors.Is(err, ErrTaskForbidden):
+		r.Code = http.StatusForbidden
+	case errors.Is(err, ErrTaskVersionConflict):
+		r.Code = http.StatusPreconditionFailed
+	case errors.Is(err, ErrTaskStatusConflict), errors.Is(err, ErrTaskBlocked):
+		r.Code = http.StatusConflict
+	default:
+		log.Printf("bulk task %s: %s\n", r.TaskID, err.Error())
+		r.Code = http.StatusInternalServerError
+		r.Error = http.StatusText(r.Code)
+	}
+}
+
+// advisoryLockKey turns the lock name into a Postgres advisory lock key, so the keys of
+// the different locks sharing the database don't collide unless their names do
+func advisoryLockKey(name string) int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(name))
+
+	//nolint:gosec // The overflow is fine, the key only has to be the same for the same name
+	return int64(hash.Sum64())
+}
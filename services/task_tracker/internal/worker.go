@@ -3,25 +3,40 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
+	"github.com/vashc/async_arch_course/pkg/events"
+	"github.com/vashc/async_arch_course/pkg/events/schema"
 )
 
 func NewWorker(config *Config, storage *Storage, rabbitClient *RabbitClient) *Worker {
-	return &Worker{
+	w := &Worker{
 		config:  config,
 		storage: storage,
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
 		rabbitClient: rabbitClient,
+		dispatcher:   events.NewDispatcher(),
 	}
+
+	w.dispatcher.OnUserCreatedV1(w.userCreated)
+	w.dispatcher.OnUserUpdatedV1(w.userUpdated)
+
+	return w
 }
 
 func (w *Worker) Process(ctx context.Context, queueName string) error {
+	err := w.rabbitClient.DeclareRetryQueues(queueName, w.retryDelays())
+	if err != nil {
+		return err
+	}
+
 	queue, err := w.rabbitClient.Listen(queueName)
 	if err != nil {
 		return err
@@ -31,37 +46,149 @@ func (w *Worker) Process(ctx context.Context, queueName string) error {
 		select {
 		case <-ctx.Done():
 			return nil
-		case msg := <-queue:
+		case msg, ok := <-queue:
+			if !ok {
+				return ErrConsumerClosed
+			}
+
 			err = w.processOne(&msg)
 			if err != nil {
 				log.Printf("task_tracker.processOne error: %s\n", err.Error())
+				err = w.retry(queueName, &msg, err)
+			} else {
+				err = msg.Ack(false)
+			}
+
+			if err != nil {
+				log.Printf("task_tracker.ack error: %s\n", err.Error())
 			}
 		}
 	}
 }
 
-func (w *Worker) processOne(msg *amqp.Delivery) (err error) {
-	switch msg.Type {
-	case string(userCreatedEventType):
-		userCreatedIn := new(UserCreatedIn)
-		err = json.Unmarshal(msg.Body, &userCreatedIn)
-		if err != nil {
-			return err
-		}
+// retry sends the failed message to the delay queue of the next attempt. The message is parked
+// once it runs out of retries or it can't ever be processed. If the message can't be republished,
+// it's returned to the queue instead
+func (w *Worker) retry(queueName string, msg *amqp.Delivery, cause error) error {
+	retries := headerInt(msg.Headers, RabbitHeaderRetryCount)
+	headers := amqp.Table{
+		RabbitHeaderLastError: cause.Error(),
+	}
 
-		user := &User{
-			ID:       userCreatedIn.ID,
-			Username: userCreatedIn.Username,
-			Role:     userCreatedIn.Role,
+	var err error
+	if retries < w.config.Consumer.MaxRetries && !permanentError(cause) {
+		headers[RabbitHeaderRetryCount] = int32(retries + 1)
+		err = w.rabbitClient.Republish(retryQueueName(queueName, w.retryDelays()[retries]), msg, headers)
+	} else {
+		// The dead letters are looked up by ID, so a message without one gets it here
+		if msg.MessageId == "" {
+			msg.MessageId = uuid.NewString()
 		}
 
-		err = w.storage.CreateUser(user)
-		if err != nil {
-			return err
-		}
+		headers[RabbitHeaderFailedAt] = time.Now().UTC()
+		log.Printf("Parking %s event %s after %d retries\n", msg.Type, msg.MessageId, retries)
+		err = w.rabbitClient.Republish(queueName+RabbitParkingSuffix, msg, headers)
+	}
+
+	if err != nil {
+		return msg.Nack(false, true)
+	}
+
+	return msg.Ack(false)
+}
+
+// retryDelays doubles the delay with every retry
+func (w *Worker) retryDelays() []time.Duration {
+	delays := make([]time.Duration, w.config.Consumer.MaxRetries)
+	for i := range delays {
+		delays[i] = w.config.Consumer.RetryBackoff << i
+	}
+
+	return delays
+}
+
+// permanentError tells whether the message fails no matter how many times it's retried
+func permanentError(err error) bool {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxError), errors.As(err, &typeError):
+		return true
+	case errors.Is(err, events.ErrInvalidEnvelope):
+		return true
+	case errors.Is(err, schema.ErrUnknownSchema), errors.Is(err, schema.ErrSchemaViolation):
+		return true
+	case errors.Is(err, events.ErrUnsupportedVersion):
+		// A newer producer is ahead of the consumer, the event is replayed from the parking queue after the upgrade
+		return true
+	default:
+		return false
+	}
+}
+
+// processOne applies the event, a redelivered event is recognized by its ID and skipped.
+// The events the worker has no handlers for are skipped as well, though a version the worker
+// can't handle of an event it does handle fails, so the event gets parked instead of lost
+func (w *Worker) processOne(msg *amqp.Delivery) error {
+	envelope, err := events.Parse(msg)
+	if err != nil {
+		return err
+	}
+
+	// The queue gets every event of the producers, the ones the worker doesn't handle aren't validated
+	if !w.dispatcher.Handles(envelope.EventName) {
+		return nil
+	}
+
+	err = w.rabbitClient.Validate(envelope)
+	if err != nil {
+		return err
+	}
+
+	err = w.dispatcher.Dispatch(envelope)
+
+	switch {
+	case errors.Is(err, ErrDuplicateEvent):
+		log.Printf("Skipping %s event %s, it has been processed already\n", envelope.EventName, envelope.EventID)
+		return nil
 	default:
+		return err
+	}
+}
+
+func (w *Worker) userCreated(envelope *events.Envelope, data *events.UserCreatedV1) error {
+	user := &User{
+		ID:       data.ID,
+		Username: data.Username,
+		Role:     Role(data.Role),
+		Skills:   NormalizeTags(data.Skills),
+	}
+
+	return w.storage.CreateUser(user, inboxEvent(envelope))
+}
+
+func (w *Worker) userUpdated(envelope *events.Envelope, data *events.UserUpdatedV1) error {
+	user := &User{
+		ID:       data.ID,
+		Username: data.Username,
+		Role:     Role(data.Role),
+		Skills:   NormalizeTags(data.Skills),
+	}
+
+	return w.storage.UpsertUser(user, inboxEvent(envelope))
+}
+
+// inboxEvent records the event ID. The messages published before the events got
+// their IDs have none, they are processed without deduplication
+func inboxEvent(envelope *events.Envelope) *InboxEvent {
+	if envelope.EventID == uuid.Nil {
+		log.Printf("%s event has no ID, it's not deduplicated\n", envelope.EventName)
 		return nil
 	}
 
-	return nil
+	return &InboxEvent{
+		EventID:   envelope.EventID,
+		EventType: EventType(envelope.EventName),
+	}
 }
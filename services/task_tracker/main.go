@@ -32,8 +32,17 @@ func main() {
 	}
 	defer client.Close()
 
+	// Initialize attachments blob store
+	blobStore, err := tasktracker.NewBlobStore(config)
+	if err != nil {
+		log.Fatalf("task_tracker.NewBlobStore error: %s", err.Error())
+	}
+
+	// Task events are pushed to the users' streams through the hub
+	hub := tasktracker.NewHub(config)
+
 	// Create new chi application service
-	service := tasktracker.NewService(config, storage, client)
+	service := tasktracker.NewService(config, storage, client, hub, blobStore)
 
 	// Instantiate routes
 	service.InstantiateRoutes()
@@ -42,12 +51,48 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	worker := tasktracker.NewWorker(config, storage, client)
 	go func() {
-		err = worker.Process(ctx, tasktracker.RabbitQueue)
+		err := worker.Process(ctx, tasktracker.RabbitQueue)
 		if err != nil {
 			log.Fatalf("worker.Process error: %s", err.Error())
 		}
 	}()
 
+	// Start overdue tasks scanner
+	scanner := tasktracker.NewScanner(config, storage)
+	go func() {
+		err := scanner.Process(ctx)
+		if err != nil {
+			log.Fatalf("scanner.Process error: %s", err.Error())
+		}
+	}()
+
+	// Start outbox relay, it publishes the events stored along with the changes
+	relay := tasktracker.NewRelay(config, storage, client)
+	go func() {
+		err := relay.Process(ctx)
+		if err != nil {
+			log.Fatalf("relay.Process error: %s", err.Error())
+		}
+	}()
+
+	// Start outbox streamer, it pushes the committed events to the streams of this replica
+	streamer := tasktracker.NewStreamer(config, storage, hub)
+	go func() {
+		err := streamer.Process(ctx)
+		if err != nil {
+			log.Fatalf("streamer.Process error: %s", err.Error())
+		}
+	}()
+
+	// Start task templates scheduler
+	scheduler := tasktracker.NewScheduler(config, storage, service)
+	go func() {
+		err := scheduler.Process(ctx)
+		if err != nil {
+			log.Fatalf("scheduler.Process error: %s", err.Error())
+		}
+	}()
+
 	// Handle graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -56,7 +101,7 @@ func main() {
 		<-c
 		log.Println("Gracefully shutting down")
 		cancel()
-		if err = service.Stop(); err != nil {
+		if err := service.Stop(); err != nil {
 			log.Fatalf("service.Stop error: %s", err.Error())
 		}
 	}()